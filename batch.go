@@ -0,0 +1,226 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Batch is a list of inserts and deletes that are applied to a list together by Apply. Either
+// all of them are applied, or none of them are.
+type Batch[K, V any] struct {
+	ops []batchOp[K, V]
+}
+
+type batchOp[K, V any] struct {
+	// Inserts have a key and value, deletes have bounds
+	insert bool
+	key    K
+	value  V
+	lo, hi Bound[K]
+}
+
+// NewBatch returns an empty batch for the list.
+func (this *Skiplist[K, V]) NewBatch() *Batch[K, V] {
+	return &Batch[K, V]{}
+}
+
+func (this *Batch[K, V]) Insert(key K, value V) {
+	this.ops = append(this.ops, batchOp[K, V]{insert: true, key: key, value: value})
+}
+
+func (this *Batch[K, V]) Delete(key K) {
+	this.DeleteBounds(Inclusive(key), Inclusive(key))
+}
+
+func (this *Batch[K, V]) DeleteRange(key1, key2 K) {
+	this.DeleteBounds(Inclusive(key1), Inclusive(key2))
+}
+
+func (this *Batch[K, V]) DeleteBounds(lo, hi Bound[K]) {
+	this.ops = append(this.ops, batchOp[K, V]{lo: lo, hi: hi})
+}
+
+// Len returns the number of operations in the batch.
+func (this *Batch[K, V]) Len() int {
+	return len(this.ops)
+}
+
+// Reset empties the batch so it can be reused.
+func (this *Batch[K, V]) Reset() {
+	clear(this.ops)
+	this.ops = this.ops[:0]
+}
+
+// Apply applies the operations in the batch, in order, while holding the write lock, so readers
+// see either none or all of them. If any operation fails, e.g. because the comparator returns an
+// error, or a key is already in a unique list, the operations already applied are rolled back
+// and the list is left as it was. ErrDuplicateKey is returned as is. A batch that is rolled back
+// is not written to the log.
+func (this *Skiplist[K, V]) Apply(batch *Batch[K, V]) (err error) {
+	if err = this.checkBatch("Apply", batch); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.apply("Apply", batch)
+}
+
+// checkBatch returns an error if any operation in the batch has keys that can't be compared.
+func (this *Skiplist[K, V]) checkBatch(name string, batch *Batch[K, V]) (err error) {
+	for i, op := range batch.ops {
+		if op.insert {
+			err = this.checkKey(name, op.key)
+		} else {
+			err = this.checkBounds(name, op.lo, op.hi)
+		}
+
+		if err != nil {
+			return errors.New("skiplist/" + name + ": invalid operation " + strconv.Itoa(i) + "; " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// apply applies the operations in the batch, or none of them if one fails. The caller must hold
+// the write lock.
+func (this *Skiplist[K, V]) apply(name string, batch *Batch[K, V]) (err error) {
+	undo := make([]change[K, V], 0, len(batch.ops))
+
+	for i, op := range batch.ops {
+		if op.insert {
+			var p *node[K, V]
+			if !this.unique {
+				err = this.locateEnd(op.key)
+			} else if p, err = this.locate(op.key); err == nil && p != nil {
+				err = ErrDuplicateKey
+			}
+
+			if err == nil {
+				p = this.insert(op.key, op.value)
+				undo = append(undo, this.newChange(this.insertFingers, p, true))
+			}
+		} else {
+			_, err = this.deleteRange(name, op.lo, op.hi, &undo)
+		}
+
+		if err != nil {
+			this.rollback(undo)
+
+			if err == ErrDuplicateKey {
+				return err
+			}
+			return errors.New("skiplist/" + name + ": operation " + strconv.Itoa(i) + " failed, batch rolled back; " + err.Error())
+		}
+	}
+
+	// The batch is logged only once it applied cleanly, so replaying the log never depends on
+	// an operation failing again
+	if err = this.logBatch(name, batch); err != nil {
+		this.rollback(undo)
+		return err
+	}
+
+	return nil
+}
+
+// change is a node inserted or deleted by a batch, with the rightmost nodes before it at each
+// level of the list at the time, and their spans. The key and value of a deleted node are kept,
+// since they are zeroed when it's unlinked from an arena.
+type change[K, V any] struct {
+	node     *node[K, V]
+	key      K
+	value    V
+	fingers  []*node[K, V]
+	spans    []int
+	level    int
+	inserted bool
+}
+
+// newChange records p, which was just inserted, or is about to be deleted, with the fingers
+// before it. The caller must hold the write lock.
+func (this *Skiplist[K, V]) newChange(f *fingers[K, V], p *node[K, V], inserted bool) change[K, V] {
+	c := change[K, V]{
+		node:     p,
+		key:      p.key,
+		value:    p.value,
+		fingers:  append([]*node[K, V](nil), f.nodes[:this.level]...),
+		spans:    make([]int, this.level),
+		level:    this.level,
+		inserted: inserted,
+	}
+
+	for l, q := range c.fingers {
+		c.spans[l] = q.span[l]
+	}
+
+	return c
+}
+
+// rollback undoes the changes, starting from the last one. Each change is undone when the list is
+// exactly as it was right after that change, so the recorded fingers are still the nodes before
+// it, and no keys have to be compared. The caller must hold the write lock.
+func (this *Skiplist[K, V]) rollback(undo []change[K, V]) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		c := undo[i]
+		p := c.node
+
+		switch {
+		case c.inserted:
+			this.unlink(&fingers[K, V]{nodes: c.fingers}, p)
+
+		case p.dead != 0:
+			// p was kept as a tombstone for a snapshot, and it's the last one
+			p.dead = 0
+			for l := 0; l < c.level; l++ {
+				if q := c.fingers[l]; q.next[l] != nil {
+					q.span[l]++
+				}
+			}
+
+			this.tombstones[len(this.tombstones)-1] = nil
+			this.tombstones = this.tombstones[:len(this.tombstones)-1]
+			this.count++
+
+		default:
+			// p was unlinked, and still points to the nodes it was linked to. The spans of the
+			// links to p are restored as they were, they can't be computed from the current
+			// spans if p was the last node at that level.
+			p.key, p.value = c.key, c.value
+			this.level = c.level
+			for l := 0; l < c.level; l++ {
+				q := c.fingers[l]
+				if l < len(p.next) {
+					q.span[l] = c.spans[l]
+					q.next[l] = p
+				} else if q.next[l] != nil {
+					q.span[l]++
+				}
+			}
+
+			if p.next[0] != nil {
+				p.next[0].prev = p
+			}
+			this.count++
+		}
+	}
+
+	// The fingers may be positioned on nodes that are not in the list anymore
+	this.version++
+}
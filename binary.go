@@ -0,0 +1,368 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// The binary format starts with a header:
+//
+//	magic       "SKPL"
+//	version     uvarint
+//	comparator  string, the name of the comparator function
+//	key type    string
+//	value type  string
+//	max level   uvarint
+//	1/p         uvarint
+//	unique      byte
+//	count       uvarint
+//
+// followed by count key/value pairs in list order, each encoded with the list's codecs. Strings,
+// keys and values are prefixed with their length as a uvarint.
+//
+// The comparator name is the function name reported by the runtime, e.g. "main.byLength", so it
+// changes if the function is renamed or its package is, and closures get generated names like
+// "main.main.func1" that change as other closures are added before them. SetCompareName sets a
+// stable name instead.
+const (
+	binaryMagic   = "SKPL"
+	binaryVersion = 1
+
+	// Upper bounds for lengths read from the data, to fail early on corrupt data
+	maxNameLength = 1 << 16
+	maxDataLength = 1<<32 - 1
+	maxLevelLimit = 64
+
+	// Lengths are read in chunks of up to this size, so a corrupt length can't allocate more
+	// memory than there is data
+	readChunkSize = 1 << 20
+)
+
+// SetCodecs sets the codecs MarshalBinary and UnmarshalBinary use for the keys and values.
+func (this *Skiplist[K, V]) SetCodecs(keys Codec[K], values Codec[V]) (err error) {
+	if keys == nil || values == nil {
+		return errors.New("skiplist/SetCodecs: trying to set codec to nil")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.keyCodec, this.valueCodec = keys, values
+	return nil
+}
+
+// SetCompareName sets the comparator name that MarshalBinary, WriteMapped and checkpoints record,
+// and that loading checks, in place of the name of the comparator function. It has to be
+// called again after SetCompare.
+func (this *Skiplist[K, V]) SetCompareName(name string) (err error) {
+	if name == "" {
+		return errors.New("skiplist/SetCompareName: name is empty")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.compareName = name
+	return nil
+}
+
+// MarshalBinary encodes the list, including its max level, probability and unique mode, using
+// the list's codecs. Tombstones kept for snapshots are not included.
+func (this *Skiplist[K, V]) MarshalBinary() (data []byte, err error) {
+	var buf bytes.Buffer
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	p := this.headNode
+	next := func() (key K, value V, ok bool) {
+		if p = this.skip(p.next[0], current, false); p == nil {
+			return
+		}
+		return p.key, p.value, true
+	}
+
+	if err = this.encode(&buf, this.info(), next); err != nil {
+		return nil, errors.New("skiplist/MarshalBinary: " + err.Error())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the contents of the list with data encoded by MarshalBinary. The list
+// must have been created with the same comparator and key and value types. Since the data is
+// sorted, the list is rebuilt in O(n) time, without searching for the position of each node.
+// If data is invalid, the list is left unchanged.
+func (this *Skiplist[K, V]) UnmarshalBinary(data []byte) (err error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	l, err := this.decode(r)
+	if err != nil {
+		return errors.New("skiplist/UnmarshalBinary: " + err.Error())
+	}
+
+	if _, err = r.ReadByte(); err != io.EOF {
+		return errors.New("skiplist/UnmarshalBinary: unexpected data after the last node")
+	}
+
+	this.load(l)
+	return nil
+}
+
+// info is what encode needs to know about a list, besides its nodes.
+type info[K, V any] struct {
+	compareName string
+	maxLevel    int
+	ip          int
+	unique      bool
+	count       int
+	keyCodec    Codec[K]
+	valueCodec  Codec[V]
+}
+
+// info returns the list's info for encode. The caller must hold the lock.
+func (this *Skiplist[K, V]) info() info[K, V] {
+	return info[K, V]{
+		compareName: this.compareName,
+		maxLevel:    this.maxLevel,
+		ip:          this.ip,
+		unique:      this.unique,
+		count:       this.count,
+		keyCodec:    this.keyCodec,
+		valueCodec:  this.valueCodec,
+	}
+}
+
+// encode writes a list described by h to w, with the h.count nodes returned by next. It doesn't
+// need the lock, unless next does.
+func (this *Skiplist[K, V]) encode(w io.Writer, h info[K, V], next func() (K, V, bool)) (err error) {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+
+	// Errors are kept by the bufio.Writer, and returned by Flush
+	writeUvarint := func(u uint64) {
+		buf = binary.AppendUvarint(buf[:0], u)
+		bw.Write(buf)
+	}
+
+	writeBytes := func(b []byte) {
+		writeUvarint(uint64(len(b)))
+		bw.Write(b)
+	}
+
+	bw.WriteString(binaryMagic)
+	writeUvarint(binaryVersion)
+	writeBytes([]byte(h.compareName))
+	writeBytes([]byte(reflect.TypeFor[K]().String()))
+	writeBytes([]byte(reflect.TypeFor[V]().String()))
+	writeUvarint(uint64(h.maxLevel))
+	writeUvarint(uint64(h.ip))
+	if h.unique {
+		bw.WriteByte(1)
+	} else {
+		bw.WriteByte(0)
+	}
+	writeUvarint(uint64(h.count))
+
+	var data []byte
+	for i := 0; i < h.count; i++ {
+		key, value, ok := next()
+		if !ok {
+			return errors.New("list has fewer nodes than its count")
+		}
+
+		if data, err = h.keyCodec.Append(data[:0], key); err != nil {
+			return errors.New("error encoding key; " + err.Error())
+		}
+		writeBytes(data)
+
+		if data, err = h.valueCodec.Append(data[:0], value); err != nil {
+			return errors.New("error encoding value; " + err.Error())
+		}
+		writeBytes(data)
+	}
+
+	return bw.Flush()
+}
+
+// decoded is a list read by decode, which can replace the contents of a list with load.
+type decoded[K, V any] struct {
+	headNode *node[K, V]
+	maxLevel int
+	ip       int
+	unique   bool
+	level    int
+	count    int
+	seq      uint64
+}
+
+// decode reads a list written by encode from r. The caller must hold the write lock, so that the
+// sequence numbers of the nodes follow the list's.
+func (this *Skiplist[K, V]) decode(r *bufio.Reader) (l *decoded[K, V], err error) {
+	if len(this.snapshots) > 0 {
+		return nil, errors.New("list has open snapshots")
+	}
+
+	if this.wal != nil {
+		return nil, errors.New("list has a write-ahead log")
+	}
+
+	readBytes := func(limit uint64, buf []byte) ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		} else if n > limit {
+			return nil, errors.New("invalid length " + strconv.FormatUint(n, 10))
+		}
+
+		// Data that is really n bytes long makes buf grow to n bytes, but a corrupt n runs out
+		// of data first
+		for buf = buf[:0]; uint64(len(buf)) < n; {
+			m := len(buf)
+			buf = slices.Grow(buf, int(min(n-uint64(m), readChunkSize)))
+			buf = buf[:min(uint64(cap(buf)), n)]
+
+			if _, err = io.ReadFull(r, buf[m:]); err != nil {
+				return nil, err
+			}
+		}
+
+		return buf, nil
+	}
+
+	// Header
+	magic := make([]byte, len(binaryMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != binaryMagic {
+		return nil, errors.New("data is not a skiplist")
+	}
+
+	if version, err := binary.ReadUvarint(r); err != nil {
+		return nil, errors.New("error reading header; " + err.Error())
+	} else if version != binaryVersion {
+		return nil, errors.New("unsupported version " + strconv.FormatUint(version, 10))
+	}
+
+	for _, expected := range []string{this.compareName, reflect.TypeFor[K]().String(), reflect.TypeFor[V]().String()} {
+		name, err := readBytes(maxNameLength, nil)
+		if err != nil {
+			return nil, errors.New("error reading header; " + err.Error())
+		} else if string(name) != expected {
+			return nil, errors.New("data has " + string(name) + ", the list has " + expected)
+		}
+	}
+
+	var header [2]uint64
+	for i := range header {
+		if header[i], err = binary.ReadUvarint(r); err != nil {
+			return nil, errors.New("error reading header; " + err.Error())
+		}
+	}
+
+	unique, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("error reading header; " + err.Error())
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.New("error reading header; " + err.Error())
+	}
+
+	maxLevel, ip := int(header[0]), int(header[1])
+	if maxLevel < 1 || maxLevel > maxLevelLimit || ip < 1 {
+		return nil, errors.New("invalid max level or probability")
+	}
+
+	// Nodes are appended after the last node at each level, so no keys have to be compared,
+	// except to check that the data is sorted
+	head := newNode[K, V](maxLevel)
+	last := make([]*node[K, V], maxLevel)
+	index := make([]int, maxLevel)
+	for i := range last {
+		last[i] = head
+	}
+
+	level, seq := 1, this.seq
+	var data []byte
+
+	for i := 1; uint64(i) <= count; i++ {
+		n := this.newNode(randomLevel(maxLevel, ip))
+
+		if data, err = readBytes(maxDataLength, data); err != nil {
+			return nil, errors.New("error reading key; " + err.Error())
+		} else if n.key, err = this.keyCodec.Decode(data); err != nil {
+			return nil, errors.New("error decoding key; " + err.Error())
+		}
+
+		if data, err = readBytes(maxDataLength, data); err != nil {
+			return nil, errors.New("error reading value; " + err.Error())
+		} else if n.value, err = this.valueCodec.Decode(data); err != nil {
+			return nil, errors.New("error decoding value; " + err.Error())
+		}
+
+		if p := last[0]; p != head {
+			if c, err := this.compare(p.key, n.key); err != nil {
+				return nil, errors.New("error comparing keys; " + err.Error())
+			} else if c > 0 {
+				return nil, errors.New("keys are not sorted")
+			} else if c == 0 && unique != 0 {
+				return nil, errors.New("duplicate key in a unique list")
+			}
+		}
+
+		n.prev = last[0]
+		for l := range n.next {
+			last[l].next[l] = n
+			last[l].span[l] = i - index[l]
+			last[l], index[l] = n, i
+		}
+		seq++
+		n.seq = seq
+
+		if len(n.next) > level {
+			level = len(n.next)
+		}
+	}
+
+	return &decoded[K, V]{
+		headNode: head,
+		maxLevel: maxLevel,
+		ip:       ip,
+		unique:   unique != 0,
+		level:    level,
+		count:    int(count),
+		seq:      seq,
+	}, nil
+}
+
+// load replaces the contents of the list with l. The caller must hold the write lock.
+func (this *Skiplist[K, V]) load(l *decoded[K, V]) {
+	this.headNode = l.headNode
+	this.maxLevel, this.ip, this.unique = l.maxLevel, l.ip, l.unique
+	this.level, this.count, this.seq = l.level, l.count, l.seq
+	this.tombstones = nil
+	this.version++
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type boundKind int
+
+const (
+	unbounded boundKind = iota
+	inclusive
+	exclusive
+)
+
+// Bound is one end of a range of keys. It either includes its key in the range, excludes it, or
+// leaves that end of the range unbounded. The zero Bound is unbounded.
+type Bound[K any] struct {
+	key  K
+	kind boundKind
+}
+
+// Inclusive returns a bound that includes key in the range.
+func Inclusive[K any](key K) Bound[K] {
+	return Bound[K]{key: key, kind: inclusive}
+}
+
+// Exclusive returns a bound that excludes key from the range.
+func Exclusive[K any](key K) Bound[K] {
+	return Bound[K]{key: key, kind: exclusive}
+}
+
+// Unbounded returns a bound that doesn't limit its end of the range.
+func Unbounded[K any]() Bound[K] {
+	return Bound[K]{}
+}
+
+// Key returns the key of the bound, or the zero K if the bound is unbounded.
+func (this Bound[K]) Key() K {
+	return this.key
+}
+
+func (this Bound[K]) IsInclusive() bool {
+	return this.kind == inclusive
+}
+
+func (this Bound[K]) IsExclusive() bool {
+	return this.kind == exclusive
+}
+
+func (this Bound[K]) IsUnbounded() bool {
+	return this.kind == unbounded
+}
+
+// SelectBounds returns a lazy iterator over the nodes between lo and hi, e.g. [a, b) is
+// SelectBounds(Inclusive(a), Exclusive(b)), and (a, +inf) is SelectBounds(Exclusive(a),
+// Unbounded[K]()).
+func (this *Skiplist[K, V]) SelectBounds(lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("SelectBounds", lo, hi, nil, current)
+}
+
+// DeleteBounds deletes the nodes between lo and hi, and returns them in an iterator.
+func (this *Skiplist[K, V]) DeleteBounds(lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	return this.deleteBounds("DeleteBounds", lo, hi)
+}
+
+// checkBounds returns an error if the keys of lo or hi can't be compared. name is the name of the
+// calling method, used in the error messages.
+func (this *Skiplist[K, V]) checkBounds(name string, lo, hi Bound[K]) error {
+	if (lo.kind != unbounded && this.isNil(lo.key)) || (hi.kind != unbounded && this.isNil(hi.key)) {
+		return errors.New("skiplist/" + name + ": key1 or key2 is nil")
+	}
+
+	if lo.kind != unbounded && hi.kind != unbounded && !this.sameType(lo.key, hi.key) {
+		return fmt.Errorf("skiplist/%s: k1.(%s) and k2.(%s) have different types", name,
+			reflect.TypeOf(lo.key).Name(), reflect.TypeOf(hi.key).Name())
+	}
+
+	if this.compare == nil {
+		return errors.New("skiplist/" + name + ": comparator is not set (== nil)")
+	}
+
+	return nil
+}
+
+// afterLo returns true if key is within the lower bound lo.
+func (this *Skiplist[K, V]) afterLo(lo Bound[K], key K) (bool, error) {
+	if lo.kind == unbounded {
+		return true, nil
+	}
+
+	c, err := this.compare(key, lo.key)
+	if err != nil {
+		return false, errors.New("skiplist/afterLo: error comparing keys; " + err.Error())
+	}
+
+	return c > 0 || (c == 0 && lo.kind == inclusive), nil
+}
+
+// beforeHi returns true if key is within the upper bound hi.
+func (this *Skiplist[K, V]) beforeHi(hi Bound[K], key K) (bool, error) {
+	if hi.kind == unbounded {
+		return true, nil
+	}
+
+	c, err := this.compare(key, hi.key)
+	if err != nil {
+		return false, errors.New("skiplist/beforeHi: error comparing keys; " + err.Error())
+	}
+
+	return c < 0 || (c == 0 && hi.kind == inclusive), nil
+}
+
+// seekLo positions the fingers on the rightmost nodes before the first node within lo, at each
+// level. The caller must hold the lock.
+func (this *Skiplist[K, V]) seekLo(lo Bound[K], f *fingers[K, V]) error {
+	if lo.kind == unbounded {
+		f.fit(len(this.headNode.next))
+		for i := 0; i < this.level; i++ {
+			f.nodes[i] = this.headNode
+		}
+		f.version = this.version
+		return nil
+	}
+
+	// An exclusive bound starts after the nodes with the same key
+	if err := this.updateSearchFingers(lo.key, lo.kind == exclusive, f); err != nil {
+		return errors.New("skiplist/seek: error finding node; " + err.Error())
+	}
+
+	return nil
+}
+
+// seekHi returns the last node within hi, or headNode if there's none, using the fingers f. The
+// caller must hold the lock.
+func (this *Skiplist[K, V]) seekHi(hi Bound[K], f *fingers[K, V]) (*node[K, V], error) {
+	switch hi.kind {
+	case inclusive:
+		return this.atOrBefore(hi.key)
+	case exclusive:
+		return this.before(hi.key, f)
+	}
+
+	return this.last(), nil
+}
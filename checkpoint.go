@@ -0,0 +1,204 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A checkpoint has the LSN of the last log record it includes, as a uvarint, followed by the list
+// in the MarshalBinary format, and the CRC-32C of both, as a little endian uint32. It's written to
+// a temporary file first, which then replaces the checkpoint, so a crash leaves either the old
+// checkpoint or the new one.
+const (
+	checkpointFile = "skiplist.checkpoint"
+	checkpointTemp = checkpointFile + ".tmp"
+)
+
+// Checkpoint writes the contents of a list opened with Open to a checkpoint file in its directory,
+// and deletes the log segments it includes. Open then loads the checkpoint, and only replays the
+// writes made after it, so calling Checkpoint periodically keeps the time it takes to reopen the
+// list from growing with the number of writes. The checkpoint is written from a snapshot, so the
+// list can still be changed while it's being written.
+func (this *Skiplist[K, V]) Checkpoint() (err error) {
+	this.checkpoint.Lock()
+	defer this.checkpoint.Unlock()
+
+	this.mutex.Lock()
+	w := this.wal
+	if w == nil {
+		this.mutex.Unlock()
+		return errors.New("skiplist/Checkpoint: list is not open")
+	}
+
+	// The segments before the new one only have records in the checkpoint
+	if err = w.rotate(); err != nil {
+		this.mutex.Unlock()
+		return errors.New("skiplist/Checkpoint: error starting a log segment; " + err.Error())
+	}
+
+	lsn, h, snap := w.lsn, this.info(), this.snapshot()
+	this.mutex.Unlock()
+
+	defer snap.Close()
+
+	if err = this.writeCheckpoint(w.dir, lsn, h, snap); err != nil {
+		return errors.New("skiplist/Checkpoint: " + err.Error())
+	}
+
+	if err = removeSegments(w.dir, lsn); err != nil {
+		return errors.New("skiplist/Checkpoint: error removing log segments; " + err.Error())
+	}
+
+	return nil
+}
+
+// writeCheckpoint writes the nodes in snap, described by h, to the checkpoint file in dir.
+func (this *Skiplist[K, V]) writeCheckpoint(dir string, lsn uint64, h info[K, V], snap *Snapshot[K, V]) (err error) {
+	iter, err := snap.SelectBounds(Unbounded[K](), Unbounded[K]())
+	if err != nil {
+		return err
+	}
+
+	next := func() (key K, value V, ok bool) {
+		if !iter.Next() {
+			return
+		}
+		return iter.Key(), iter.Value(), true
+	}
+
+	temp := filepath.Join(dir, checkpointTemp)
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(temp)
+		}
+	}()
+
+	crc := crc32.New(crcTable)
+	w := io.MultiWriter(file, crc)
+
+	if _, err = w.Write(binary.AppendUvarint(nil, lsn)); err != nil {
+		return err
+	}
+
+	if err = this.encode(w, h, next); err != nil {
+		return err
+	}
+
+	if err = iter.Err(); err != nil {
+		return err
+	}
+
+	if _, err = file.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(temp, filepath.Join(dir, checkpointFile)); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// restore loads the checkpoint in dir into the list, and returns the LSN of the last log record
+// it includes, or 0 if there's no checkpoint. The caller must hold the write lock.
+func (this *Skiplist[K, V]) restore(dir string) (lsn uint64, err error) {
+	// A temporary file is left by a crash in the middle of a checkpoint
+	if err = os.Remove(filepath.Join(dir, checkpointTemp)); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	file, err := os.Open(filepath.Join(dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	} else if info.Size() < 4 {
+		return 0, errors.New("checkpoint is corrupt")
+	}
+
+	crc := crc32.New(crcTable)
+	r := bufio.NewReader(io.TeeReader(io.NewSectionReader(file, 0, info.Size()-4), crc))
+
+	if lsn, err = binary.ReadUvarint(r); err != nil {
+		return 0, errors.New("error reading checkpoint; " + err.Error())
+	}
+
+	l, err := this.decode(r)
+	if err != nil {
+		return 0, errors.New("error reading checkpoint; " + err.Error())
+	}
+
+	if _, err = r.ReadByte(); err != io.EOF {
+		return 0, errors.New("checkpoint is corrupt, unexpected data after the last node")
+	}
+
+	var sum [4]byte
+	if _, err = file.ReadAt(sum[:], info.Size()-4); err != nil {
+		return 0, err
+	} else if binary.LittleEndian.Uint32(sum[:]) != crc.Sum32() {
+		return 0, errors.New("checkpoint is corrupt, bad checksum")
+	}
+
+	this.load(l)
+	return lsn, nil
+}
+
+// removeSegments deletes the log segments in dir with records up to lsn only.
+func removeSegments(dir string, lsn uint64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// The segment starting after lsn is the oldest one to keep
+	keep := segmentName(lsn + 1)
+	for _, e := range entries {
+		if name := e.Name(); isSegment(name) && name < keep {
+			if err = os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
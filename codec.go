@@ -0,0 +1,259 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Codec encodes and decodes the keys or values of a list for MarshalBinary and UnmarshalBinary.
+// The encoded keys and values are framed by the list, so a codec doesn't need to record their
+// length.
+type Codec[T any] interface {
+	// Append appends the encoding of v to buf and returns the extended buffer.
+	Append(buf []byte, v T) ([]byte, error)
+
+	// Decode decodes a value from data, which is exactly what Append appended.
+	Decode(data []byte) (T, error)
+}
+
+// Type tags of the builtin codec
+const (
+	tagString byte = iota + 1
+	tagBytes
+	tagBool
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagUintptr
+	tagFloat32
+	tagFloat64
+
+	// A nil interface{}, only used by dynamic codecs
+	tagNil
+)
+
+type builtinCodec[T any] struct {
+	// dynamic is true if T is an interface type, in which case each value is prefixed with the
+	// tag of its dynamic type
+	dynamic bool
+}
+
+// BuiltinCodec returns a codec for string, []byte, bool, int, int8, int16, int32, int64, uint,
+// uint8, uint16, uint32, uint64, uintptr, float32 and float64 values. Named types based on them,
+// and the other types the builtin comparators support, such as time.Time, *big.Int and Lesser
+// implementations, need their own Codec. If T is an interface type, such as the interface{} keys
+// and values of lists created with New, each value records its own type, and nil is supported
+// too. Integers that don't fit the type they are decoded to are an error. Lists use BuiltinCodec
+// for keys and values unless SetCodecs is called.
+func BuiltinCodec[T any]() Codec[T] {
+	return builtinCodec[T]{dynamic: reflect.TypeFor[T]().Kind() == reflect.Interface}
+}
+
+func (this builtinCodec[T]) Append(buf []byte, v T) ([]byte, error) {
+	if this.dynamic && any(v) == nil {
+		return append(buf, tagNil), nil
+	}
+
+	tag := builtinTag(v)
+	if tag == 0 {
+		return buf, fmt.Errorf("skiplist/BuiltinCodec: unsupported type %s", reflect.TypeOf(v))
+	}
+
+	if this.dynamic {
+		buf = append(buf, tag)
+	}
+
+	switch v := any(v).(type) {
+	case string:
+		buf = append(buf, v...)
+	case []byte:
+		buf = append(buf, v...)
+	case bool:
+		if v {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case int:
+		buf = binary.AppendVarint(buf, int64(v))
+	case int8:
+		buf = binary.AppendVarint(buf, int64(v))
+	case int16:
+		buf = binary.AppendVarint(buf, int64(v))
+	case int32:
+		buf = binary.AppendVarint(buf, int64(v))
+	case int64:
+		buf = binary.AppendVarint(buf, v)
+	case uint:
+		buf = binary.AppendUvarint(buf, uint64(v))
+	case uint8:
+		buf = binary.AppendUvarint(buf, uint64(v))
+	case uint16:
+		buf = binary.AppendUvarint(buf, uint64(v))
+	case uint32:
+		buf = binary.AppendUvarint(buf, uint64(v))
+	case uint64:
+		buf = binary.AppendUvarint(buf, v)
+	case uintptr:
+		buf = binary.AppendUvarint(buf, uint64(v))
+	case float32:
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+	case float64:
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	}
+
+	return buf, nil
+}
+
+func (this builtinCodec[T]) Decode(data []byte) (v T, err error) {
+	tag := builtinTag(v)
+	if this.dynamic {
+		if len(data) == 0 {
+			return v, errors.New("skiplist/BuiltinCodec: missing type")
+		}
+		tag, data = data[0], data[1:]
+	}
+
+	var x any
+	ok := true
+	switch tag {
+	case tagNil:
+		if !this.dynamic || len(data) != 0 {
+			return v, errors.New("skiplist/BuiltinCodec: invalid nil")
+		}
+		return v, nil
+	case tagString:
+		x = string(data)
+	case tagBytes:
+		x = append([]byte(nil), data...)
+	case tagBool:
+		if len(data) != 1 {
+			return v, errors.New("skiplist/BuiltinCodec: invalid bool")
+		}
+		x = data[0] != 0
+	case tagInt, tagInt8, tagInt16, tagInt32, tagInt64:
+		i, n := binary.Varint(data)
+		if n <= 0 || n != len(data) {
+			return v, errors.New("skiplist/BuiltinCodec: invalid varint")
+		}
+
+		switch tag {
+		case tagInt:
+			x, ok = int(i), int64(int(i)) == i
+		case tagInt8:
+			x, ok = int8(i), int64(int8(i)) == i
+		case tagInt16:
+			x, ok = int16(i), int64(int16(i)) == i
+		case tagInt32:
+			x, ok = int32(i), int64(int32(i)) == i
+		default:
+			x = i
+		}
+	case tagUint, tagUint8, tagUint16, tagUint32, tagUint64, tagUintptr:
+		u, n := binary.Uvarint(data)
+		if n <= 0 || n != len(data) {
+			return v, errors.New("skiplist/BuiltinCodec: invalid uvarint")
+		}
+
+		switch tag {
+		case tagUint:
+			x, ok = uint(u), uint64(uint(u)) == u
+		case tagUint8:
+			x, ok = uint8(u), uint64(uint8(u)) == u
+		case tagUint16:
+			x, ok = uint16(u), uint64(uint16(u)) == u
+		case tagUint32:
+			x, ok = uint32(u), uint64(uint32(u)) == u
+		case tagUintptr:
+			x, ok = uintptr(u), uint64(uintptr(u)) == u
+		default:
+			x = u
+		}
+	case tagFloat32:
+		if len(data) != 4 {
+			return v, errors.New("skiplist/BuiltinCodec: invalid float32")
+		}
+		x = math.Float32frombits(binary.LittleEndian.Uint32(data))
+	case tagFloat64:
+		if len(data) != 8 {
+			return v, errors.New("skiplist/BuiltinCodec: invalid float64")
+		}
+		x = math.Float64frombits(binary.LittleEndian.Uint64(data))
+	default:
+		return v, fmt.Errorf("skiplist/BuiltinCodec: unsupported type %s", reflect.TypeFor[T]())
+	}
+
+	if !ok {
+		return v, fmt.Errorf("skiplist/BuiltinCodec: integer out of range for %T", x)
+	}
+
+	if v, ok := x.(T); ok {
+		return v, nil
+	}
+
+	return v, fmt.Errorf("skiplist/BuiltinCodec: %T is not a %s", x, reflect.TypeFor[T]())
+}
+
+// builtinTag returns the type tag of v, or 0 if the builtin codec doesn't support its type.
+func builtinTag(v any) byte {
+	switch v.(type) {
+	case string:
+		return tagString
+	case []byte:
+		return tagBytes
+	case bool:
+		return tagBool
+	case int:
+		return tagInt
+	case int8:
+		return tagInt8
+	case int16:
+		return tagInt16
+	case int32:
+		return tagInt32
+	case int64:
+		return tagInt64
+	case uint:
+		return tagUint
+	case uint8:
+		return tagUint8
+	case uint16:
+		return tagUint16
+	case uint32:
+		return tagUint32
+	case uint64:
+		return tagUint64
+	case uintptr:
+		return tagUintptr
+	case float32:
+		return tagFloat32
+	case float64:
+		return tagFloat64
+	}
+
+	return 0
+}
@@ -15,18 +15,114 @@
 package skiplist
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
+	"time"
 )
 
+// Comparator returns true if k1 sorts before k2.
 type Comparator func(k1, k2 interface{}) (bool, error)
 
+// Compare is a three-way comparator. It returns a negative number if k1 sorts before k2, a
+// positive number if k1 sorts after k2, and zero if the two keys are equal.
+type Compare func(k1, k2 interface{}) (int, error)
+
+// Comparer is the set of comparator types accepted by New.
+type Comparer interface {
+	Comparator | Compare
+}
+
+// Lesser is implemented by key types that order themselves. The builtin comparators call Less on
+// keys of types they don't support otherwise, with other of the same type as the receiver.
+type Lesser interface {
+	Less(other interface{}) bool
+}
+
 var (
 	BuiltinLessThan    Comparator = builtinLessThan
 	BuiltinGreaterThan Comparator = builtinGreaterThan
 	BuiltinEqual       Comparator = builtinEqual
+	BuiltinCompare     Compare    = builtinCompare
 )
 
+// FromLessThan adapts a less-than comparator, such as BuiltinLessThan or BuiltinGreaterThan,
+// to a three-way comparator. Two keys are equal if neither of them sorts before the other.
+func FromLessThan[K any](less func(k1, k2 K) (bool, error)) func(k1, k2 K) (int, error) {
+	return func(k1, k2 K) (int, error) {
+		if res, err := less(k1, k2); err != nil || res {
+			return -1, err
+		}
+
+		if res, err := less(k2, k1); err != nil || res {
+			return 1, err
+		}
+
+		return 0, nil
+	}
+}
+
+func builtinCompare(k1, k2 interface{}) (int, error) {
+	if reflect.TypeOf(k1) != reflect.TypeOf(k2) {
+		return 0, fmt.Errorf("skiplist/BuiltinCompare: k1.(%s) and k2.(%s) have different types",
+			reflect.TypeOf(k1).Name(), reflect.TypeOf(k2).Name())
+	}
+
+	switch k1 := k1.(type) {
+	case string:
+		return cmp.Compare(k1, k2.(string)), nil
+
+	case int64:
+		return cmp.Compare(k1, k2.(int64)), nil
+
+	case int32:
+		return cmp.Compare(k1, k2.(int32)), nil
+
+	case int16:
+		return cmp.Compare(k1, k2.(int16)), nil
+
+	case int8:
+		return cmp.Compare(k1, k2.(int8)), nil
+
+	case int:
+		return cmp.Compare(k1, k2.(int)), nil
+
+	case float32:
+		return cmp.Compare(k1, k2.(float32)), nil
+
+	case float64:
+		return cmp.Compare(k1, k2.(float64)), nil
+
+	case uint:
+		return cmp.Compare(k1, k2.(uint)), nil
+
+	case uint8:
+		return cmp.Compare(k1, k2.(uint8)), nil
+
+	case uint16:
+		return cmp.Compare(k1, k2.(uint16)), nil
+
+	case uint32:
+		return cmp.Compare(k1, k2.(uint32)), nil
+
+	case uint64:
+		return cmp.Compare(k1, k2.(uint64)), nil
+
+	case uintptr:
+		return cmp.Compare(k1, k2.(uintptr)), nil
+	}
+
+	if c, ok, err := compareOther("BuiltinCompare", k1, k2); ok {
+		return c, err
+	}
+
+	return 0, fmt.Errorf("skiplist/BuiltinCompare: unsupported types for k1.(%s) and k2.(%s)",
+		reflect.TypeOf(k1).Name(), reflect.TypeOf(k2).Name())
+}
+
 func builtinLessThan(k1, k2 interface{}) (bool, error) {
 	if reflect.TypeOf(k1) != reflect.TypeOf(k2) {
 		return false, fmt.Errorf("skiplist/BuiltinLessThan: k1.(%s) and k2.(%s) have different types",
@@ -77,6 +173,10 @@ func builtinLessThan(k1, k2 interface{}) (bool, error) {
 		return k1 < k2.(uintptr), nil
 	}
 
+	if c, ok, err := compareOther("BuiltinLessThan", k1, k2); ok {
+		return c < 0, err
+	}
+
 	return false, fmt.Errorf("skiplist/BuiltinLessThan: unsupported types for k1.(%s) and k2.(%s)",
 		reflect.TypeOf(k1).Name(), reflect.TypeOf(k2).Name())
 }
@@ -131,6 +231,10 @@ func builtinGreaterThan(k1, k2 interface{}) (bool, error) {
 		return k1 > k2.(uintptr), nil
 	}
 
+	if c, ok, err := compareOther("BuiltinGreaterThan", k1, k2); ok {
+		return c > 0, err
+	}
+
 	return false, fmt.Errorf("skiplist/BuiltinGreaterThan: unsupported types for k1.(%s) and k2.(%s)",
 		reflect.TypeOf(k1).Name(), reflect.TypeOf(k2).Name())
 }
@@ -185,6 +289,83 @@ func builtinEqual(k1, k2 interface{}) (bool, error) {
 		return k1 == k2.(uintptr), nil
 	}
 
-	return false, fmt.Errorf("skiplist/BuiltinLessThan: unsupported types for k1.(%s) and k2.(%s)",
+	if c, ok, err := compareOther("BuiltinEqual", k1, k2); ok {
+		return c == 0, err
+	}
+
+	return false, fmt.Errorf("skiplist/BuiltinEqual: unsupported types for k1.(%s) and k2.(%s)",
 		reflect.TypeOf(k1).Name(), reflect.TypeOf(k2).Name())
 }
+
+// compareOther compares keys of the same type that the builtin comparators don't switch on:
+// time.Time, []byte, bool, *big.Int, *big.Float, Lessers, and named types, by their underlying
+// kind. It returns false if the type isn't supported. name is the name of the calling comparator,
+// used in the error messages.
+func compareOther(name string, k1, k2 interface{}) (c int, ok bool, err error) {
+	switch k1 := k1.(type) {
+	case time.Time:
+		return k1.Compare(k2.(time.Time)), true, nil
+
+	case []byte:
+		return bytes.Compare(k1, k2.([]byte)), true, nil
+
+	case bool:
+		return compareBool(k1, k2.(bool)), true, nil
+
+	case *big.Int:
+		if k1 == nil || k2.(*big.Int) == nil {
+			return 0, true, errors.New("skiplist/" + name + ": k1 or k2 is a nil *big.Int")
+		}
+		return k1.Cmp(k2.(*big.Int)), true, nil
+
+	case *big.Float:
+		if k1 == nil || k2.(*big.Float) == nil {
+			return 0, true, errors.New("skiplist/" + name + ": k1 or k2 is a nil *big.Float")
+		}
+		return k1.Cmp(k2.(*big.Float)), true, nil
+
+	case Lesser:
+		if k1.Less(k2) {
+			return -1, true, nil
+		} else if k2.(Lesser).Less(k1) {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	}
+
+	v1, v2 := reflect.ValueOf(k1), reflect.ValueOf(k2)
+	switch v1.Kind() {
+	case reflect.String:
+		return cmp.Compare(v1.String(), v2.String()), true, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(v1.Int(), v2.Int()), true, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(v1.Uint(), v2.Uint()), true, nil
+
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(v1.Float(), v2.Float()), true, nil
+
+	case reflect.Bool:
+		return compareBool(v1.Bool(), v2.Bool()), true, nil
+
+	case reflect.Slice:
+		if v1.Type().Elem().Kind() == reflect.Uint8 {
+			return bytes.Compare(v1.Bytes(), v2.Bytes()), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// compareBool sorts false before true.
+func compareBool(b1, b2 bool) int {
+	switch {
+	case b1 == b2:
+		return 0
+	case b1:
+		return 1
+	}
+	return -1
+}
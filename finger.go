@@ -0,0 +1,51 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+// Finger is a search finger owned by the caller. Each search through a Finger starts from where
+// the previous one ended, so a sequence of searches for nearby keys takes O(log k) time, where k
+// is the distance between the keys, instead of O(log n).
+//
+// The list's own searches are safe for concurrent use, but don't keep fingers between calls. A
+// Finger must not be used by more than one goroutine at a time, but each goroutine can have its
+// own Finger on the same list.
+type Finger[K, V any] struct {
+	list    *Skiplist[K, V]
+	fingers *fingers[K, V]
+}
+
+// NewFinger returns a new search finger for the list.
+func (this *Skiplist[K, V]) NewFinger() *Finger[K, V] {
+	return &Finger[K, V]{
+		list:    this,
+		fingers: newFingers[K, V](len(this.headNode.next)),
+	}
+}
+
+func (this *Finger[K, V]) Select(key K) (iter *Iterator[K, V], err error) {
+	return this.list.selectBounds("Select", Inclusive(key), Inclusive(key), this.fingers, current)
+}
+
+func (this *Finger[K, V]) SelectRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.list.selectBounds("SelectRange", Inclusive(key1), Inclusive(key2), this.fingers, current)
+}
+
+func (this *Finger[K, V]) SelectBounds(lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	return this.list.selectBounds("SelectBounds", lo, hi, this.fingers, current)
+}
+
+func (this *Finger[K, V]) Get(key K) (value V, ok bool, err error) {
+	return this.list.get(key, this.fingers)
+}
@@ -14,51 +14,447 @@
 
 package skiplist
 
-type Iterator struct {
+import (
+	"errors"
+	"sort"
+)
+
+// Positions of a lazy iterator
+const (
+	beforeFirst = -1
+	onNode      = 0
+	afterLast   = 1
+)
+
+// Iterator walks the nodes returned by Select, SelectRange, Delete, DeleteRange and Iterate.
+// Keys and values are returned as K and V, so no type assertion is needed for typed lists.
+// Iterators can move in both directions with Next and Prev, and can be positioned with First,
+// Last, SeekGE and SeekLE.
+//
+// Iterators returned by Delete and DeleteRange hold the deleted nodes. Iterators returned by
+// Select, SelectRange and Iterate are lazy: they don't copy the range, but walk the list one
+// node at a time, and stop at the bounds of the range. Each step takes the list's read lock,
+// so nodes inserted or deleted while iterating may or may not be seen. Lazy iterators don't
+// hold any resources between calls, so they can be abandoned at any point. The iterators of a
+// transaction are lazy too, and merge its writes with its snapshot as they go.
+type Iterator[K, V any] struct {
 	// buffered nodes
-	buf []*node
+	buf []*node[K, V]
 
 	// total count
 	count int
 
 	// current position
 	cur int
+
+	// Comparison function used to seek in buffered nodes
+	compare func(k1, k2 K) (int, error)
+
+	// The list a lazy iterator walks, nil for buffered iterators
+	list *Skiplist[K, V]
+
+	// A lazy iterator only returns the nodes that are visible as of this sequence number, which
+	// is current unless the iterator comes from a snapshot
+	seq uint64
+
+	// Lazy iterators return the nodes between the lo and hi bounds
+	lo, hi Bound[K]
+
+	// Position of a lazy iterator: beforeFirst, onNode or afterLast
+	pos int
+
+	// Search fingers of a lazy iterator, allocated the first time it has to search the list
+	fingers *fingers[K, V]
+
+	// Current node of a lazy iterator. Before the first node, it can be set to the node
+	// right before the range so that Next doesn't have to search for it.
+	node *node[K, V]
+
+	// The key and value of node, and the list's version, when the iterator moved to it. If the
+	// list changed since, node may have been unlinked, and zeroed if it's from an arena, so the
+	// iterator moves on by searching for the key again.
+	key     K
+	value   V
+	version uint64
+
+	// Whether count has been computed for a lazy iterator
+	counted bool
+
+	// Error from the comparator while walking the list
+	err error
+
+	// A transaction's reads merge its writes with its snapshot, see Txn
+	merge *merge[K, V]
 }
 
-func newIterator() *Iterator {
-	return &Iterator{
-		buf:   make([]*node, 0, 50),
+func newIterator[K, V any]() *Iterator[K, V] {
+	return &Iterator[K, V]{
+		buf:   make([]*node[K, V], 0, 50),
 		count: 0,
 		cur:   -1,
 	}
 }
 
-func (this *Iterator) Next() bool {
-	this.cur++
-	if this.cur >= this.count {
+// newRangeIterator creates a lazy iterator over the nodes between lo and hi that are visible as of
+// seq. If prev is not nil, it must be the rightmost node before lo, and the iterator starts from
+// there unless the list changes first. The caller must hold the read lock in that case.
+func newRangeIterator[K, V any](list *Skiplist[K, V], seq uint64, lo, hi Bound[K], prev *node[K, V]) *Iterator[K, V] {
+	iter := &Iterator[K, V]{
+		list: list,
+		seq:  seq,
+		lo:   lo,
+		hi:   hi,
+		pos:  beforeFirst,
+		node: prev,
+		cur:  -1,
+	}
+
+	if prev != nil {
+		iter.version = list.version
+	}
+	return iter
+}
+
+// Next moves the iterator to the next node, and returns false if there are no more nodes.
+// Calling Next on a new or rewound iterator moves it to the first node.
+func (this *Iterator[K, V]) Next() bool {
+	if this.merge != nil {
+		return this.merge.next()
+	}
+
+	if this.list == nil {
+		if this.cur < this.count {
+			this.cur++
+		}
+		return this.cur < this.count
+	}
+
+	switch this.pos {
+	case beforeFirst:
+		if this.node == nil {
+			return this.First()
+		}
+	case afterLast:
+		return false
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	if this.pos == beforeFirst {
+		if this.version != this.list.version {
+			return this.first()
+		}
+		return this.moveTo(this.node.next[0], false)
+	}
+
+	return this.step(false)
+}
+
+// Prev moves the iterator to the previous node, and returns false if there are no more nodes.
+// Calling Prev on an iterator that moved past the end moves it to the last node.
+func (this *Iterator[K, V]) Prev() bool {
+	if this.merge != nil {
+		return this.merge.prev()
+	}
+
+	if this.list == nil {
+		if this.cur >= 0 {
+			this.cur--
+		}
+		return this.cur >= 0
+	}
+
+	switch this.pos {
+	case beforeFirst:
+		return false
+	case afterLast:
+		return this.Last()
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	return this.step(true)
+}
+
+// step moves a lazy iterator from the node it's on to the next node, or the previous one if
+// backward is true. The caller must hold the list's read lock.
+func (this *Iterator[K, V]) step(backward bool) bool {
+	n, err := this.list.resume(this.node, this.key, this.version, this.searchFingers(), backward)
+	if err != nil {
+		return this.fail(err)
+	}
+
+	return this.moveTo(n, backward)
+}
+
+// First moves the iterator to the first node, and returns false if there are no nodes.
+func (this *Iterator[K, V]) First() bool {
+	if this.merge != nil {
+		return this.merge.first()
+	}
+
+	if this.list == nil {
+		this.cur = 0
+		return this.cur < this.count
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	return this.first()
+}
+
+// Last moves the iterator to the last node, and returns false if there are no nodes.
+func (this *Iterator[K, V]) Last() bool {
+	if this.merge != nil {
+		return this.merge.last()
+	}
+
+	if this.list == nil {
+		this.cur = this.count - 1
+		return this.cur >= 0
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	return this.last()
+}
+
+// SeekGE moves the iterator to the first node with a key at or after key, and returns false
+// if there is no such node. The iterator is left past the end in that case.
+func (this *Iterator[K, V]) SeekGE(key K) bool {
+	if this.merge != nil {
+		return this.merge.seekGE(key)
+	}
+
+	if this.list == nil {
+		this.cur = this.search(key, false)
+		return this.cur < this.count
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	// If key is before the range, the first node in the range is the answer
+	if ok, err := this.list.afterLo(this.lo, key); err != nil {
+		return this.fail(err)
+	} else if !ok {
+		return this.first()
+	}
+
+	p, err := this.list.before(key, this.searchFingers())
+	if err != nil {
+		return this.fail(err)
+	}
+
+	return this.moveTo(p.next[0], false)
+}
+
+// SeekLE moves the iterator to the last node with a key at or before key, and returns false
+// if there is no such node. The iterator is left before the first node in that case.
+func (this *Iterator[K, V]) SeekLE(key K) bool {
+	if this.merge != nil {
+		return this.merge.seekLE(key)
+	}
+
+	if this.list == nil {
+		this.cur = this.search(key, true) - 1
+		return this.cur >= 0
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	// If key is after the range, the last node in the range is the answer
+	if ok, err := this.list.beforeHi(this.hi, key); err != nil {
+		return this.fail(err)
+	} else if !ok {
+		return this.last()
+	}
+
+	p, err := this.list.atOrBefore(key)
+	if err != nil {
+		return this.fail(err)
+	}
+
+	return this.moveTo(p, true)
+}
+
+func (this *Iterator[K, V]) first() bool {
+	f := this.searchFingers()
+	if err := this.list.seekLo(this.lo, f); err != nil {
+		return this.fail(err)
+	}
+
+	return this.moveTo(f.nodes[0].next[0], false)
+}
+
+func (this *Iterator[K, V]) last() bool {
+	p, err := this.list.seekHi(this.hi, this.searchFingers())
+	if err != nil {
+		return this.fail(err)
+	}
+
+	return this.moveTo(p, true)
+}
+
+// searchFingers returns the iterator's own search fingers. Iterators are used by one goroutine at
+// a time, so they can keep their fingers between searches.
+func (this *Iterator[K, V]) searchFingers() *fingers[K, V] {
+	if this.fingers == nil {
+		this.fingers = newFingers[K, V](len(this.list.headNode.next))
+	}
+
+	return this.fingers
+}
+
+// moveTo positions a lazy iterator on n, or the first visible node from n in the direction it's
+// moving, if that node is within the range. Otherwise the iterator is positioned before the first
+// node if moving backward, or past the last node if moving forward. The caller must hold the
+// list's read lock.
+func (this *Iterator[K, V]) moveTo(n *node[K, V], backward bool) bool {
+	this.node = nil
+	this.pos = afterLast
+	if backward {
+		this.pos = beforeFirst
+	}
+
+	n = this.list.skip(n, this.seq, backward)
+
+	if n == nil || n == this.list.headNode {
+		return false
+	}
+
+	var ok bool
+	var err error
+	if backward {
+		ok, err = this.list.afterLo(this.lo, n.key)
+	} else {
+		ok, err = this.list.beforeHi(this.hi, n.key)
+	}
+
+	if err != nil {
+		return this.fail(err)
+	} else if !ok {
 		return false
 	}
+
+	this.node, this.pos = n, onNode
+	this.key, this.value, this.version = n.key, n.value, this.list.version
 	return true
 }
 
-func (this *Iterator) Key() interface{} {
+func (this *Iterator[K, V]) fail(err error) bool {
+	this.err = errors.New("skiplist/Iterator: " + err.Error())
+	this.node, this.pos = nil, afterLast
+	return false
+}
+
+// search returns the index of the first buffered node with a key at or after key, or at or
+// before key if after is true.
+func (this *Iterator[K, V]) search(key K, after bool) int {
+	return sort.Search(this.count, func(i int) bool {
+		c, err := this.compare(this.buf[i].key, key)
+		if err != nil {
+			this.err = errors.New("skiplist/Iterator: error comparing keys; " + err.Error())
+		}
+		return c > 0 || (c == 0 && !after)
+	})
+}
+
+// Key returns the key at the current position, or the zero K (nil for interface{} keys)
+// if the iterator is not positioned on a node.
+func (this *Iterator[K, V]) Key() (key K) {
+	if this.merge != nil {
+		if this.merge.pos == onNode {
+			key = this.merge.cur.Key()
+		}
+		return
+	}
+
+	if this.list != nil {
+		if this.pos == onNode {
+			key = this.key
+		}
+		return
+	}
+
 	if this.cur < 0 || this.cur >= this.count {
-		return nil
+		return
 	}
 	return this.buf[this.cur].GetKey()
 }
 
-func (this *Iterator) Value() interface{} {
+// Value returns the value at the current position, or the zero V if the iterator is not
+// positioned on a node. Values can be replaced by Upsert, so lazy iterators read them under the
+// list's read lock. If the node has been deleted since the iterator moved to it, the value it had
+// then is returned.
+func (this *Iterator[K, V]) Value() (value V) {
+	if this.merge != nil {
+		if this.merge.pos == onNode {
+			value = this.merge.cur.Value()
+		}
+		return
+	}
+
+	if this.list != nil {
+		if this.pos == onNode {
+			this.list.mutex.RLock()
+			if value = this.value; this.node.linked() {
+				value = this.node.GetValue()
+			}
+			this.list.mutex.RUnlock()
+		}
+		return
+	}
+
 	if this.cur < 0 || this.cur >= this.count {
-		return nil
+		return
 	}
 	return this.buf[this.cur].GetValue()
 }
 
-func (this *Iterator) Rewind() {
+// Rewind moves the iterator back to before the first node. Lazy iterators search the list
+// for the start of the range again, so they pick up nodes inserted since they were created.
+func (this *Iterator[K, V]) Rewind() {
+	if this.merge != nil {
+		this.merge.inserts.Rewind()
+		this.merge.base.Rewind()
+		this.merge.cur, this.merge.pos, this.merge.err = nil, beforeFirst, nil
+		return
+	}
+
+	if this.list != nil {
+		this.node, this.pos, this.err = nil, beforeFirst, nil
+		return
+	}
+
 	this.cur = -1
 }
 
-func (this *Iterator) Count() int {
+// Count returns the number of nodes in the iterator. Lazy iterators walk the range to count
+// the nodes the first time Count is called, and the result is cached after that.
+func (this *Iterator[K, V]) Count() int {
+	if this.merge != nil {
+		return this.merge.total()
+	}
+
+	if this.list != nil && !this.counted {
+		this.count, this.err = this.list.countRange(this.lo, this.hi, this.seq)
+		this.counted = true
+	}
+
 	return this.count
 }
+
+// Err returns the error, if any, that stopped a lazy iterator early.
+func (this *Iterator[K, V]) Err() error {
+	if this.merge != nil {
+		return this.merge.err
+	}
+
+	return this.err
+}
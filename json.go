@@ -0,0 +1,137 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// jsonNode is the JSON encoding of a node.
+type jsonNode[K, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+// MarshalJSON encodes the list as an array of {"key": ..., "value": ...} objects, in list order.
+// Nodes with the same key are in the order they were inserted, so decoding the array with
+// UnmarshalJSON gives the same list.
+func (this *Skiplist[K, V]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := this.EncodeJSON(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON replaces the contents of the list with an array encoded by MarshalJSON. Keys and
+// values are decoded with encoding/json, so for lists created with New, numbers are decoded as
+// float64. If data is invalid, the list is left unchanged.
+func (this *Skiplist[K, V]) UnmarshalJSON(data []byte) error {
+	return this.DecodeJSON(bytes.NewReader(data))
+}
+
+// EncodeJSON writes the list to w in the same format as MarshalJSON, one node at a time, without
+// building the whole document in memory. It reads from a snapshot of the list, so the list can be
+// changed while it's being written, and the changes are not included.
+func (this *Skiplist[K, V]) EncodeJSON(w io.Writer) (err error) {
+	snap := this.Snapshot()
+	defer snap.Close()
+
+	iter, err := snap.SelectBounds(Unbounded[K](), Unbounded[K]())
+	if err != nil {
+		return errors.New("skiplist/EncodeJSON: " + err.Error())
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+
+	for first := true; iter.Next(); first = false {
+		data, err := json.Marshal(jsonNode[K, V]{iter.Key(), iter.Value()})
+		if err != nil {
+			return errors.New("skiplist/EncodeJSON: error encoding node; " + err.Error())
+		}
+
+		if !first {
+			bw.WriteByte(',')
+		}
+		bw.Write(data)
+	}
+
+	if err = iter.Err(); err != nil {
+		return errors.New("skiplist/EncodeJSON: " + err.Error())
+	}
+
+	bw.WriteByte(']')
+	return bw.Flush()
+}
+
+// DecodeJSON reads an array in the format written by EncodeJSON from r, one node at a time, and
+// replaces the contents of the list with it. If the array is invalid, the list is left unchanged.
+func (this *Skiplist[K, V]) DecodeJSON(r io.Reader) (err error) {
+	this.mutex.RLock()
+	list := newSkiplist[K, V](this.compare)
+	list.setMaxLevel(this.maxLevel)
+	list.ip, list.unique, list.seq = this.ip, this.unique, this.seq
+	this.mutex.RUnlock()
+
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return errors.New("skiplist/DecodeJSON: expected an array")
+	}
+
+	for dec.More() {
+		var n jsonNode[K, V]
+		if err = dec.Decode(&n); err != nil {
+			return errors.New("skiplist/DecodeJSON: error decoding node; " + err.Error())
+		}
+
+		if _, err = list.Insert(n.Key, n.Value); err != nil {
+			return errors.New("skiplist/DecodeJSON: error inserting node; " + err.Error())
+		}
+	}
+
+	if t, err := dec.Token(); err != nil || t != json.Delim(']') {
+		return errors.New("skiplist/DecodeJSON: expected the end of the array")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.snapshots) > 0 {
+		return errors.New("skiplist/DecodeJSON: list has open snapshots")
+	}
+
+	if this.wal != nil {
+		return errors.New("skiplist/DecodeJSON: list has a write-ahead log")
+	}
+
+	this.load(&decoded[K, V]{
+		headNode: list.headNode,
+		maxLevel: list.maxLevel,
+		ip:       list.ip,
+		unique:   list.unique,
+		level:    list.level,
+		count:    list.count,
+		seq:      max(list.seq, this.seq),
+	})
+
+	return nil
+}
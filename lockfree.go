@@ -0,0 +1,429 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+)
+
+// LockFree is a concurrent skiplist that doesn't use locks. Inserts and deletes are done with
+// compare-and-swap operations on the links between nodes, following the lock-free skiplist in
+// Herlihy and Shavit, The Art of Multiprocessor Programming, chapter 14. Any number of goroutines
+// can insert, delete and select at the same time without waiting for each other.
+//
+// LockFree has the same Insert, Select, SelectRange, Delete and DeleteRange semantics as Skiplist,
+// including duplicate keys, which stay in the order they were inserted. Both
+// implement List, so code written against List can choose between them where the list is created,
+// with NewLockFree, NewLockFreeOrdered or NewLockFreeFunc instead of the Skiplist constructors.
+// The node returned by Insert and the iterators hold copies of the inserted, selected or deleted
+// nodes.
+//
+// Each node is inserted and deleted atomically, but a range is walked one node at a time, so the
+// nodes returned by a range that other goroutines are changing may not all have been in the list
+// at the same moment.
+type LockFree[K, V any] struct {
+	// See Skiplist for maxLevel and ip
+	maxLevel int
+	ip       int
+
+	headNode *lfNode[K, V]
+
+	// Total number of nodes in the list
+	count atomic.Int64
+
+	// Sequence number of the last node inserted. Nodes are sorted by key, then by descending
+	// sequence number, so every node has a unique position even with duplicate keys.
+	seq atomic.Uint64
+
+	// Three-way comparison function for the node keys, see Skiplist
+	compare func(k1, k2 K) (int, error)
+
+	// See Skiplist
+	dynamic bool
+}
+
+// List is the set of methods shared by Skiplist and LockFree.
+type List[K, V any] interface {
+	Insert(key K, value V) (*node[K, V], error)
+	Select(key K) (*Iterator[K, V], error)
+	SelectRange(key1, key2 K) (*Iterator[K, V], error)
+	Delete(key K) (*Iterator[K, V], error)
+	DeleteRange(key1, key2 K) (*Iterator[K, V], error)
+	Count() int
+}
+
+var (
+	_ List[int, int] = (*Skiplist[int, int])(nil)
+	_ List[int, int] = (*LockFree[int, int])(nil)
+)
+
+type lfNode[K, V any] struct {
+	key   K
+	value V
+	seq   uint64
+
+	// next[i] is replaced as a whole by compare-and-swap, so the pointer to the next node and
+	// whether this node is deleted at level i change together.
+	next []atomic.Pointer[lfLink[K, V]]
+}
+
+type lfLink[K, V any] struct {
+	node *lfNode[K, V]
+
+	// marked is true if the node that owns the link has been deleted at that level
+	marked bool
+}
+
+// NewLockFree creates a lock-free skiplist of interface{} keys and values, ordered by compare,
+// which is either a less-than Comparator or a three-way Compare, as for New.
+func NewLockFree[C Comparer](compare C) *LockFree[interface{}, interface{}] {
+	return newLockFree[interface{}, interface{}](toCompare(compare))
+}
+
+// NewLockFreeOrdered creates a lock-free skiplist whose keys are sorted in ascending order.
+func NewLockFreeOrdered[K cmp.Ordered, V any]() *LockFree[K, V] {
+	return newLockFree[K, V](func(k1, k2 K) (int, error) {
+		return cmp.Compare(k1, k2), nil
+	})
+}
+
+// NewLockFreeFunc creates a lock-free skiplist whose keys are sorted using compare, as for NewFunc.
+func NewLockFreeFunc[K, V any](compare func(k1, k2 K) int) *LockFree[K, V] {
+	return newLockFree[K, V](func(k1, k2 K) (int, error) {
+		return compare(k1, k2), nil
+	})
+}
+
+func newLockFree[K, V any](compare func(k1, k2 K) (int, error)) *LockFree[K, V] {
+	l := DefaultMaxLevel
+
+	return &LockFree[K, V]{
+		maxLevel: l,
+		ip:       int(math.Ceil(1 / float64(DefaultProbability))),
+		headNode: newLfNode[K, V](l),
+		compare:  compare,
+		dynamic:  reflect.TypeFor[K]().Kind() == reflect.Interface,
+	}
+}
+
+func newLfNode[K, V any](l int) *lfNode[K, V] {
+	n := &lfNode[K, V]{
+		next: make([]atomic.Pointer[lfLink[K, V]], l),
+	}
+
+	for i := range n.next {
+		n.next[i].Store(&lfLink[K, V]{})
+	}
+
+	return n
+}
+
+func (this *LockFree[K, V]) Count() int {
+	return int(this.count.Load())
+}
+
+// Choose the new node's level, see Skiplist.newNodeLevel
+func (this *LockFree[K, V]) newNodeLevel() int {
+	h := 1
+
+	for h < this.maxLevel && rand.Intn(this.ip) == 0 {
+		h++
+	}
+
+	return h
+}
+
+// before returns true if n sorts before the position of key and seq.
+func (this *LockFree[K, V]) before(n *lfNode[K, V], key K, seq uint64) (bool, error) {
+	c, err := this.compare(n.key, key)
+	if err != nil {
+		return false, err
+	}
+
+	return c < 0 || (c == 0 && n.seq < seq), nil
+}
+
+// find fills preds and succs with the nodes right before and after the position of key and seq
+// at each level. Deleted nodes found on the way are unlinked.
+func (this *LockFree[K, V]) find(key K, seq uint64, preds, succs []*lfNode[K, V]) error {
+retry:
+	pred := this.headNode
+
+	for l := this.maxLevel - 1; l >= 0; l-- {
+		predLink := pred.next[l].Load()
+		curr := predLink.node
+
+		for curr != nil {
+			currLink := curr.next[l].Load()
+
+			if currLink.marked {
+				// curr is deleted, so unlink it at this level. If pred changed or was deleted
+				// in the meantime, start over.
+				if predLink.marked {
+					goto retry
+				}
+
+				link := &lfLink[K, V]{node: currLink.node}
+				if !pred.next[l].CompareAndSwap(predLink, link) {
+					goto retry
+				}
+
+				predLink, curr = link, currLink.node
+				continue
+			}
+
+			if ok, err := this.before(curr, key, seq); err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+
+			pred, predLink, curr = curr, currLink, currLink.node
+		}
+
+		preds[l], succs[l] = pred, curr
+	}
+
+	return nil
+}
+
+func (this *LockFree[K, V]) Insert(key K, value V) (*node[K, V], error) {
+	if this.dynamic && any(key) == nil {
+		return nil, errors.New("skiplist/LockFree.Insert: key is nil")
+	}
+
+	if this.compare == nil {
+		return nil, errors.New("skiplist/LockFree.Insert: comparator is not set (== nil)")
+	}
+
+	seq := this.seq.Add(1)
+	l := this.newNodeLevel()
+	n := newLfNode[K, V](l)
+	n.key, n.value, n.seq = key, value, seq
+
+	preds := make([]*lfNode[K, V], this.maxLevel)
+	succs := make([]*lfNode[K, V], this.maxLevel)
+
+	// Link the node at level 0 first, which is when it becomes part of the list
+	for {
+		if err := this.find(key, seq, preds, succs); err != nil {
+			return nil, errors.New("skiplist/LockFree.Insert: cannot find insert position, " + err.Error())
+		}
+
+		for i := 0; i < l; i++ {
+			n.next[i].Store(&lfLink[K, V]{node: succs[i]})
+		}
+
+		if this.link(preds[0], succs[0], n, 0) {
+			break
+		}
+	}
+
+	this.count.Add(1)
+
+	inserted := detachedNode(key, value)
+
+	// Then link the higher levels, which only speed up searches
+	for i := 1; i < l; i++ {
+		for {
+			link := n.next[i].Load()
+			if link.marked {
+				// The node is already being deleted
+				return inserted, nil
+			}
+
+			if link.node == succs[i] || n.next[i].CompareAndSwap(link, &lfLink[K, V]{node: succs[i]}) {
+				if this.link(preds[i], succs[i], n, i) {
+					break
+				}
+			}
+
+			if err := this.find(key, seq, preds, succs); err != nil {
+				// The node is in the list already, it just can't be linked at the higher levels
+				return inserted, nil
+			}
+		}
+	}
+
+	return inserted, nil
+}
+
+// link points pred to n at level l, if pred still points to succ and is not deleted.
+func (this *LockFree[K, V]) link(pred, succ, n *lfNode[K, V], l int) bool {
+	old := pred.next[l].Load()
+	if old.node != succ || old.marked {
+		return false
+	}
+
+	return pred.next[l].CompareAndSwap(old, &lfLink[K, V]{node: n})
+}
+
+// mark deletes n by marking all its links, from the top level down. It returns true if this
+// call marked level 0, which is when the node is deleted from the list.
+func (this *LockFree[K, V]) mark(n *lfNode[K, V]) bool {
+	for i := len(n.next) - 1; i >= 0; i-- {
+		for {
+			link := n.next[i].Load()
+			if link.marked {
+				if i == 0 {
+					return false
+				}
+				break
+			}
+
+			if n.next[i].CompareAndSwap(link, &lfLink[K, V]{node: link.node, marked: true}) {
+				break
+			}
+		}
+	}
+
+	return true
+}
+
+// first returns the first node that's not deleted, with a key at or after key. It doesn't modify
+// the list.
+func (this *LockFree[K, V]) first(key K) (*lfNode[K, V], error) {
+	pred := this.headNode
+
+	for l := this.maxLevel - 1; l >= 0; l-- {
+		for curr := pred.next[l].Load().node; curr != nil; {
+			link := curr.next[l].Load()
+			if link.marked {
+				curr = link.node
+				continue
+			}
+
+			// Equal keys sort by ascending sequence numbers, which start at 1, so 0 is before
+			// all of them
+			if ok, err := this.before(curr, key, 0); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+
+			pred, curr = curr, link.node
+		}
+	}
+
+	return this.nextLive(pred), nil
+}
+
+// nextLive returns the first node after n at level 0 that's not deleted.
+func (this *LockFree[K, V]) nextLive(n *lfNode[K, V]) *lfNode[K, V] {
+	for n = n.next[0].Load().node; n != nil; n = n.next[0].Load().node {
+		if !n.next[0].Load().marked {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// checkRange returns an error if key1 and key2 can't be compared.
+func (this *LockFree[K, V]) checkRange(name string, key1, key2 K) error {
+	if this.dynamic {
+		if any(key1) == nil || any(key2) == nil {
+			return errors.New("skiplist/LockFree." + name + ": key1 or key2 is nil")
+		}
+
+		if reflect.TypeOf(key1) != reflect.TypeOf(key2) {
+			return fmt.Errorf("skiplist/LockFree.%s: k1.(%s) and k2.(%s) have different types", name,
+				reflect.TypeOf(key1).Name(), reflect.TypeOf(key2).Name())
+		}
+	}
+
+	if this.compare == nil {
+		return errors.New("skiplist/LockFree." + name + ": comparator is not set (== nil)")
+	}
+
+	return nil
+}
+
+func (this *LockFree[K, V]) Select(key K) (iter *Iterator[K, V], err error) {
+	return this.SelectRange(key, key)
+}
+
+// SelectRange returns the nodes with key1 <= key <= key2. Nodes inserted or deleted while the
+// range is being walked may or may not be included.
+func (this *LockFree[K, V]) SelectRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.walkRange("SelectRange", key1, key2, false)
+}
+
+func (this *LockFree[K, V]) Delete(key K) (iter *Iterator[K, V], err error) {
+	return this.DeleteRange(key, key)
+}
+
+// DeleteRange deletes the nodes with key1 <= key <= key2, and returns the nodes this call
+// deleted. A node deleted by a concurrent call is only returned by that call.
+func (this *LockFree[K, V]) DeleteRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.walkRange("DeleteRange", key1, key2, true)
+}
+
+func (this *LockFree[K, V]) walkRange(name string, key1, key2 K, remove bool) (iter *Iterator[K, V], err error) {
+	if err = this.checkRange(name, key1, key2); err != nil {
+		return nil, err
+	}
+
+	p, err := this.first(key1)
+	if err != nil {
+		return nil, errors.New("skiplist/LockFree." + name + ": error finding node; " + err.Error())
+	}
+
+	iter = newIterator[K, V]()
+	iter.compare = this.compare
+
+	var preds, succs []*lfNode[K, V]
+	if remove {
+		preds = make([]*lfNode[K, V], this.maxLevel)
+		succs = make([]*lfNode[K, V], this.maxLevel)
+	}
+
+	var c int
+	for ; p != nil; p = this.nextLive(p) {
+		// Nodes inserted right after the node first stopped at can still be smaller than key1
+		if c, err = this.compare(p.key, key1); err != nil {
+			return nil, errors.New("skiplist/LockFree." + name + ": error comparing keys; " + err.Error())
+		} else if c < 0 {
+			continue
+		}
+
+		if c, err = this.compare(p.key, key2); err != nil {
+			return nil, errors.New("skiplist/LockFree." + name + ": error comparing keys; " + err.Error())
+		} else if c > 0 {
+			break
+		}
+
+		if remove {
+			if !this.mark(p) {
+				continue
+			}
+			this.count.Add(-1)
+
+			// Unlink the deleted node, find does that for every deleted node it passes by
+			this.find(p.key, p.seq, preds, succs)
+		}
+
+		iter.buf = append(iter.buf, detachedNode(p.key, p.value))
+		iter.count++
+	}
+
+	return iter, nil
+}
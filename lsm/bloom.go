@@ -0,0 +1,88 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsm
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+// bloom is a bloom filter of the keys in a table, which tells get that a table doesn't have a
+// key without reading any blocks. It's encoded as the number of hash functions, as a byte,
+// followed by the bits.
+type bloom struct {
+	k    int
+	bits []byte
+}
+
+// newBloom returns a filter of the keys with the hashes, with bitsPerKey bits for each key.
+func newBloom(hashes []uint64, bitsPerKey int) *bloom {
+	// k = ln(2) * bits per key minimizes the false positive rate
+	k := min(max(bitsPerKey*69/100, 1), 30)
+
+	n := max(len(hashes)*bitsPerKey, 64)
+	b := &bloom{k: k, bits: make([]byte, (n+7)/8)}
+
+	for _, h := range hashes {
+		b.add(h)
+	}
+
+	return b
+}
+
+func decodeBloom(data []byte) (*bloom, error) {
+	if len(data) < 2 || data[0] < 1 || data[0] > 30 {
+		return nil, errors.New("invalid bloom filter")
+	}
+
+	return &bloom{k: int(data[0]), bits: data[1:]}, nil
+}
+
+func (this *bloom) encode() []byte {
+	return append([]byte{byte(this.k)}, this.bits...)
+}
+
+// The k bit positions of a key are derived from one 64-bit hash, using double hashing
+func (this *bloom) add(h uint64) {
+	n := uint32(len(this.bits) * 8)
+	h1, h2 := uint32(h), uint32(h>>32)
+
+	for i := 0; i < this.k; i++ {
+		bit := (h1 + uint32(i)*h2) % n
+		this.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// contains returns false if the key with hash h is not in the filter, and true if it may be.
+func (this *bloom) contains(h uint64) bool {
+	n := uint32(len(this.bits) * 8)
+	h1, h2 := uint32(h), uint32(h>>32)
+
+	for i := 0; i < this.k; i++ {
+		bit := (h1 + uint32(i)*h2) % n
+		if this.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hash returns the hash of an encoded key.
+func hash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsm
+
+import (
+	"errors"
+	"math/bits"
+	"slices"
+	"time"
+
+	"github.com/zentures/skiplist"
+)
+
+// Compactions are size-tiered. Tables are in tier n if their size is between 4^n and 4^(n+1)
+// bytes, and once there are compactionTrigger consecutive tables in the same tier, they are merged
+// into one table, which is usually in a higher tier. Merging consecutive tables keeps the tables
+// ordered newest first, so the newest entry of a key is still in the first table that has it.
+
+var errCompactionStopped = errors.New("compaction stopped")
+
+// SetCompactionTrigger sets the number of tables of about the same size that are merged by a
+// background compaction. 0 turns background compactions off.
+func (this *DB[K, V]) SetCompactionTrigger(n int) (err error) {
+	if n < 0 || n == 1 {
+		return errors.New("lsm/SetCompactionTrigger: trigger must be 0 or > 1")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.compactionTrigger = n
+	this.wakeCompactor()
+	return nil
+}
+
+// SetCompactionRate limits the rate at which background compactions write tables, in bytes per
+// second, so they don't take the disk bandwidth needed by flushes and reads. 0 means no limit.
+func (this *DB[K, V]) SetCompactionRate(bytesPerSecond int) (err error) {
+	if bytesPerSecond < 0 {
+		return errors.New("lsm/SetCompactionRate: rate must be >= 0")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.compactionRate = bytesPerSecond
+	return nil
+}
+
+// Compact merges all the tables into one, leaving out overwritten and deleted entries, so they
+// no longer take space. It doesn't flush the memtable, call Flush first for that. Compact isn't
+// throttled, and it waits for a running background compaction to finish.
+func (this *DB[K, V]) Compact() (err error) {
+	if _, err = this.compact(nil, true); err != nil {
+		return errors.New("lsm/Compact: " + err.Error())
+	}
+	return nil
+}
+
+// startCompactor starts the background compaction goroutine. The caller must hold the write lock.
+func (this *DB[K, V]) startCompactor() {
+	this.wake = make(chan struct{}, 1)
+	this.stop = make(chan struct{})
+	this.done = make(chan struct{})
+	this.compactErr = nil
+
+	go this.compactor(this.wake, this.stop, this.done)
+	this.wakeCompactor()
+}
+
+// wakeCompactor tells the compactor to look for tables to compact. The caller must hold the lock.
+func (this *DB[K, V]) wakeCompactor() {
+	if this.wake == nil {
+		return
+	}
+
+	select {
+	case this.wake <- struct{}{}:
+	default:
+	}
+}
+
+// compactor runs compactions each time it's woken up, until there are none left to do, or stop is
+// closed.
+func (this *DB[K, V]) compactor(wake, stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		}
+
+		for {
+			ok, err := this.compact(stop, false)
+			if err == errCompactionStopped {
+				return
+			}
+
+			this.mutex.Lock()
+			this.compactErr = err
+			this.mutex.Unlock()
+
+			if err != nil || !ok {
+				break
+			}
+		}
+	}
+}
+
+// tier returns the size tier of a table.
+func tier(size int64) int {
+	return bits.Len64(uint64(size)) / 2
+}
+
+// pick returns the oldest run of at least compactionTrigger consecutive tables in the same tier,
+// or nil. The caller must hold the lock.
+func (this *DB[K, V]) pick() []*table[K, V] {
+	if this.compactionTrigger == 0 {
+		return nil
+	}
+
+	for end := len(this.tables); end > 0; {
+		start := end - 1
+		for start > 0 && tier(this.tables[start-1].size) == tier(this.tables[end-1].size) {
+			start--
+		}
+
+		if end-start >= this.compactionTrigger {
+			return this.tables[start:end]
+		}
+		end = start
+	}
+
+	return nil
+}
+
+// compact merges the tables picked by pick, or all of them, into one table that replaces them,
+// and returns false if there was nothing to compact. Compactions are written with the throttle
+// of the compaction rate, and stopped when stop is closed.
+func (this *DB[K, V]) compact(stop chan struct{}, all bool) (ok bool, err error) {
+	this.compactMutex.Lock()
+	defer this.compactMutex.Unlock()
+
+	this.mutex.Lock()
+	if this.dir == "" {
+		this.mutex.Unlock()
+		return false, errors.New("db is not open")
+	}
+
+	// Only compactions remove tables, so the inputs stay in the DB until they're replaced, but
+	// flushes can add tables in front of them in the meantime
+	var inputs []*table[K, V]
+	if all {
+		inputs = slices.Clone(this.tables)
+	} else {
+		inputs = slices.Clone(this.pick())
+	}
+
+	if len(inputs) == 0 {
+		this.mutex.Unlock()
+		return false, nil
+	}
+
+	for _, t := range inputs {
+		t.acquire()
+	}
+
+	// If the oldest table is compacted, there are no older entries left for deletes to hide, so
+	// they can be dropped
+	bottom := inputs[len(inputs)-1] == this.tables[len(this.tables)-1]
+
+	var o tableOptions
+	if all {
+		o = this.options(throttle(0, stop))
+	} else {
+		o = this.options(throttle(this.compactionRate, stop))
+	}
+
+	id := this.nextID
+	this.nextID++
+	this.mutex.Unlock()
+
+	defer func() {
+		for _, t := range inputs {
+			t.release()
+		}
+	}()
+
+	var ranges []rangeDelete[K]
+	sources := make([]source[K, V], len(inputs))
+	for i, t := range inputs {
+		sources[i] = newTableIterator(t, skiplist.Unbounded[K]())
+		ranges = append(ranges, t.ranges...)
+	}
+
+	m := newMerger(this.compare, sources, slices.Clone(ranges))
+	t, err := this.writeTable(id, o, func(w *tableWriter[K, V]) error {
+		for m.next() {
+			if bottom && m.entry.kind == kindDelete {
+				continue
+			}
+
+			if err := w.add(m.key, m.entry); err != nil {
+				return err
+			}
+		}
+
+		if m.err != nil {
+			return m.err
+		}
+
+		if !bottom {
+			for _, r := range ranges {
+				if err := w.addRange(r); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if err == errCompactionStopped {
+			return false, err
+		}
+		return false, errors.New("error compacting into " + tableName(id) + "; " + err.Error())
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// If everything was deleted, the output is dropped
+	empty := t.count == 0 && len(t.ranges) == 0
+
+	i := slices.Index(this.tables, inputs[0])
+	tables := slices.Clone(this.tables)
+	if empty {
+		tables = slices.Delete(tables, i, i+len(inputs))
+	} else {
+		tables = slices.Replace(tables, i, i+len(inputs), t)
+	}
+
+	if err = this.writeManifest(tables); err != nil || empty {
+		t.obsolete.Store(true)
+		t.release()
+	}
+
+	if err != nil {
+		return false, errors.New("error writing the manifest; " + err.Error())
+	}
+
+	// The inputs are deleted once the iterators reading them are closed. If that fails, they're
+	// deleted by Open, since they're no longer in the manifest.
+	this.tables = tables
+	for _, t := range inputs {
+		t.obsolete.Store(true)
+		t.release()
+	}
+
+	return true, nil
+}
+
+// throttle returns a function that sleeps as needed to keep the bytes it's called with under rate
+// per second, and fails once stop is closed. A rate of 0 means no limit.
+func throttle(rate int, stop chan struct{}) func(n int) error {
+	start := time.Now()
+	written := 0
+
+	return func(n int) error {
+		select {
+		case <-stop:
+			return errCompactionStopped
+		default:
+		}
+
+		if rate == 0 {
+			return nil
+		}
+
+		written += n
+		wait := time.Duration(float64(written)/float64(rate)*float64(time.Second)) - time.Since(start)
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-stop:
+			return errCompactionStopped
+		case <-timer.C:
+			return nil
+		}
+	}
+}
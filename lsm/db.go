@@ -0,0 +1,761 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lsm is an embeddable key/value store that uses a skiplist as its memtable, the write
+// buffer of a log-structured merge tree. Writes go to the memtable, which is made durable by its
+// write-ahead log, and once it's full, it's flushed to an immutable sorted table file. Reads
+// merge the memtable with the tables, newest first, and a background compaction merges tables
+// of about the same size, so they don't accumulate.
+package lsm
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zentures/skiplist"
+)
+
+var (
+	// Number of entries and range deletes in the memtable that triggers a flush
+	DefaultMemtableSize = 1 << 16
+
+	// Approximate size of the data blocks of the tables, in bytes
+	DefaultBlockSize = 4096
+
+	// Bits per key of the bloom filters of the tables
+	DefaultBitsPerKey = 10
+
+	// Number of tables of about the same size that triggers a compaction
+	DefaultCompactionTrigger = 4
+)
+
+// The manifest has the IDs of the tables of the DB, newest first. It's replaced atomically each
+// time a table is added or compactions replace tables, so tables that aren't in it are left over
+// from a crash, and are deleted by Open. It's the number of tables as a uvarint, followed by the
+// IDs as uvarints, and their CRC-32C as a little endian uint32.
+const manifestFile = "MANIFEST"
+
+// DB is a sorted key/value store in a directory, which has the memtable's write-ahead log, and
+// table files of flushed memtables and their compactions.
+type DB[K, V any] struct {
+	compare    func(k1, k2 K) int
+	keyCodec   skiplist.Codec[K]
+	valueCodec skiplist.Codec[V]
+
+	memtableSize      int
+	blockSize         int
+	bitsPerKey        int
+	syncPolicy        skiplist.SyncPolicy
+	syncInterval      time.Duration
+	compactionTrigger int
+	compactionRate    int
+
+	// Directory of the DB, empty if it's not open
+	dir string
+
+	// The memtable has its own lock, so writes only need the read lock to keep it from being
+	// replaced by a flush, which needs the write lock
+	mutex sync.RWMutex
+
+	// flushMutex allows one flush at a time. The flush holds the write lock to replace the
+	// memtable and to add the table, but not while it writes the table.
+	flushMutex sync.Mutex
+
+	// writeMutex orders the writes to the memtable by their sequence numbers. seq is the
+	// sequence number of the last write.
+	writeMutex sync.Mutex
+	seq        uint64
+
+	// Memtable, the memtable being flushed, if any, and the tables, newest first. Each table has
+	// a new ID, and a flushed memtable's table has the memtable's ID. If writing a table fails,
+	// its memtable stays in imm until the next flush.
+	mem    *memtable[K, V]
+	imm    *memtable[K, V]
+	tables []*table[K, V]
+	nextID uint64
+
+	// Background compaction, see compaction.go
+	compactMutex sync.Mutex
+	wake         chan struct{}
+	stop         chan struct{}
+	done         chan struct{}
+	compactErr   error
+}
+
+// NewOrdered creates a DB whose keys are sorted in ascending order using the < operator.
+func NewOrdered[K cmp.Ordered, V any]() *DB[K, V] {
+	return NewFunc[K, V](cmp.Compare[K])
+}
+
+// NewFunc creates a DB whose keys are sorted using compare, which returns a negative number if k1
+// sorts before k2, a positive number if k1 sorts after k2, and zero otherwise.
+func NewFunc[K, V any](compare func(k1, k2 K) int) *DB[K, V] {
+	return &DB[K, V]{
+		compare:           compare,
+		keyCodec:          skiplist.BuiltinCodec[K](),
+		valueCodec:        skiplist.BuiltinCodec[V](),
+		memtableSize:      DefaultMemtableSize,
+		blockSize:         DefaultBlockSize,
+		bitsPerKey:        DefaultBitsPerKey,
+		compactionTrigger: DefaultCompactionTrigger,
+	}
+}
+
+// SetCodecs sets the codecs for the keys and values in the write-ahead log and the tables. It
+// can't be called once the DB is open.
+func (this *DB[K, V]) SetCodecs(keys skiplist.Codec[K], values skiplist.Codec[V]) (err error) {
+	if keys == nil || values == nil {
+		return errors.New("lsm/SetCodecs: trying to set codec to nil")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.dir != "" {
+		return errors.New("lsm/SetCodecs: db is open")
+	}
+
+	this.keyCodec, this.valueCodec = keys, values
+	return nil
+}
+
+// SetMemtableSize sets the number of entries, including deletes, that the memtable holds before
+// it's flushed to a table.
+func (this *DB[K, V]) SetMemtableSize(n int) (err error) {
+	if n < 1 {
+		return errors.New("lsm/SetMemtableSize: size must be > 0")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.memtableSize = n
+	return nil
+}
+
+// SetBlockSize sets the approximate size of the data blocks of new tables, in bytes. Reading a
+// key from a table reads one block.
+func (this *DB[K, V]) SetBlockSize(n int) (err error) {
+	if n < 1 {
+		return errors.New("lsm/SetBlockSize: size must be > 0")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.blockSize = n
+	return nil
+}
+
+// SetBloomFilter sets the bits per key of the bloom filters of new tables, which let Get skip
+// tables that don't have a key without reading them. 10 bits per key give about 1% false
+// positives. 0 turns the filters off.
+func (this *DB[K, V]) SetBloomFilter(bitsPerKey int) (err error) {
+	if bitsPerKey < 0 {
+		return errors.New("lsm/SetBloomFilter: bits per key must be >= 0")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.bitsPerKey = bitsPerKey
+	return nil
+}
+
+// SetSyncPolicy sets when the write-ahead log of the memtable is synced to disk, see
+// skiplist.Skiplist.SetSyncPolicy.
+func (this *DB[K, V]) SetSyncPolicy(policy skiplist.SyncPolicy, interval time.Duration) (err error) {
+	if policy < skiplist.SyncAlways || policy > skiplist.SyncNever {
+		return errors.New("lsm/SetSyncPolicy: invalid policy " + strconv.Itoa(int(policy)))
+	}
+
+	if policy == skiplist.SyncInterval && interval <= 0 {
+		return errors.New("lsm/SetSyncPolicy: interval must be > 0")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.syncPolicy, this.syncInterval = policy, interval
+	if this.mem != nil {
+		if err = this.mem.points.SetSyncPolicy(policy, interval); err != nil {
+			return err
+		}
+		return this.mem.ranges.SetSyncPolicy(policy, interval)
+	}
+	return nil
+}
+
+func tableName(id uint64) string {
+	return fmt.Sprintf("%020d.sst", id)
+}
+
+func memtableName(id uint64) string {
+	return fmt.Sprintf("%020d.mem", id)
+}
+
+// parseName returns the ID of a table or memtable file name, and false if name isn't one.
+func parseName(name, suffix string) (uint64, bool) {
+	if len(name) != 20+len(suffix) || !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(name[:20], 10, 64)
+	return id, err == nil
+}
+
+// Open opens the DB in dir, creating dir if it doesn't exist. The tables in dir are opened, and
+// the write-ahead log of the memtable is replayed. A memtable left by a crash in the middle of a
+// flush is flushed again, and files left by a crash in the middle of a compaction are deleted.
+func (this *DB[K, V]) Open(dir string) (err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.dir != "" {
+		return errors.New("lsm/Open: db is already open")
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return errors.New("lsm/Open: " + err.Error())
+	}
+
+	this.dir = dir
+	if err = this.recover(); err != nil {
+		this.closeAll()
+		return errors.New("lsm/Open: " + err.Error())
+	}
+
+	this.startCompactor()
+	return nil
+}
+
+// recover opens the tables and memtables in the DB's directory. The caller must hold the write
+// lock.
+func (this *DB[K, V]) recover() error {
+	ids, found, err := readManifest(this.dir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(this.dir)
+	if err != nil {
+		return err
+	}
+
+	// Entries are sorted by name, and so the tables and memtables by ID
+	var files, mems []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// A table or manifest that was being written when the DB crashed
+			if err = os.Remove(filepath.Join(this.dir, name)); err != nil {
+				return err
+			}
+		} else if id, ok := parseName(name, ".sst"); ok {
+			files = append(files, id)
+			this.nextID = max(this.nextID, id+1)
+		} else if id, ok := parseName(name, ".mem"); ok {
+			mems = append(mems, id)
+			this.nextID = max(this.nextID, id+1)
+		}
+	}
+
+	// Without a manifest, all the tables are live, and newer tables have greater IDs
+	if !found {
+		ids = slices.Clone(files)
+		slices.Reverse(ids)
+	}
+
+	for _, id := range files {
+		if !slices.Contains(ids, id) {
+			if err = os.Remove(filepath.Join(this.dir, tableName(id))); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, id := range ids {
+		t, err := openTable(filepath.Join(this.dir, tableName(id)), id, this.compare, this.keyCodec, this.valueCodec)
+		if err != nil {
+			return errors.New(tableName(id) + ": " + err.Error())
+		}
+
+		this.tables = append(this.tables, t)
+		this.seq = max(this.seq, t.maxSeq)
+	}
+
+	if !found {
+		if err = this.writeManifest(this.tables); err != nil {
+			return err
+		}
+	}
+
+	for i, id := range mems {
+		// The memtable was flushed, but the DB crashed before it was removed
+		if slices.Contains(ids, id) {
+			if err = os.RemoveAll(filepath.Join(this.dir, memtableName(id))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		mem, err := this.openMemtable(id)
+		if err != nil {
+			return errors.New(memtableName(id) + ": " + err.Error())
+		}
+		this.seq = max(this.seq, mem.maxSeq())
+
+		// Only the newest memtable takes writes, the others were being flushed
+		if i == len(mems)-1 {
+			this.mem = mem
+			continue
+		}
+
+		this.imm = mem
+		t, err := this.writeMemtable(mem, this.options(nil))
+		if err == nil {
+			err = this.install(mem, t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if this.mem == nil {
+		if this.mem, err = this.openMemtable(this.nextID); err != nil {
+			return err
+		}
+		this.nextID++
+	}
+
+	return nil
+}
+
+// readManifest returns the table IDs in the manifest in dir, and false if there's no manifest.
+func readManifest(dir string) (ids []uint64, found bool, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if len(data) < 4 || crc32.Checksum(data[:len(data)-4], crcTable) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, false, errors.New("manifest is corrupt")
+	}
+
+	r := &reader{data: data[:len(data)-4]}
+	for n := r.uvarint(); r.err == nil && n > 0; n-- {
+		ids = append(ids, r.uvarint())
+	}
+
+	if r.err != nil || len(r.data) > 0 {
+		return nil, false, errors.New("manifest is corrupt")
+	}
+	return ids, true, nil
+}
+
+// writeManifest replaces the manifest with the IDs of tables. The caller must hold the write lock.
+func (this *DB[K, V]) writeManifest(tables []*table[K, V]) (err error) {
+	data := binary.AppendUvarint(nil, uint64(len(tables)))
+	for _, t := range tables {
+		data = binary.AppendUvarint(data, t.id)
+	}
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+
+	path := filepath.Join(this.dir, manifestFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	syncDir(this.dir)
+	return nil
+}
+
+// Close stops the background compaction, and closes the DB, after syncing the write-ahead log
+// of the memtable. Open iterators keep reading the tables until they are closed. If the last
+// background compaction failed, its error is returned.
+func (this *DB[K, V]) Close() (err error) {
+	this.mutex.Lock()
+	if this.dir == "" {
+		this.mutex.Unlock()
+		return nil
+	}
+	stop, done := this.stop, this.done
+	this.wake, this.stop, this.done = nil, nil, nil
+	this.mutex.Unlock()
+
+	// The compactor needs the lock to finish, and so do a flush and a Compact call
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	this.flushMutex.Lock()
+	defer this.flushMutex.Unlock()
+	this.compactMutex.Lock()
+	defer this.compactMutex.Unlock()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.dir == "" {
+		return nil
+	}
+
+	if err = this.closeAll(); err == nil {
+		err = this.compactErr
+	}
+
+	if err != nil {
+		return errors.New("lsm/Close: " + err.Error())
+	}
+	return nil
+}
+
+// closeAll closes the memtables, and releases the tables, and returns the first error. A memtable
+// that wasn't flushed is flushed again by Open. The caller must hold the write lock.
+func (this *DB[K, V]) closeAll() (err error) {
+	for _, mem := range this.memtables() {
+		if merr := mem.close(); err == nil {
+			err = merr
+		}
+	}
+
+	for _, t := range this.tables {
+		if terr := t.release(); err == nil {
+			err = terr
+		}
+	}
+
+	this.mem, this.imm, this.tables, this.dir = nil, nil, nil, ""
+	return err
+}
+
+// memtables returns the memtable, and the memtable being flushed if there's one, newest first.
+// The caller must hold the lock.
+func (this *DB[K, V]) memtables() []*memtable[K, V] {
+	mems := make([]*memtable[K, V], 0, 2)
+	if this.mem != nil {
+		mems = append(mems, this.mem)
+	}
+
+	if this.imm != nil {
+		mems = append(mems, this.imm)
+	}
+
+	return mems
+}
+
+// Put sets the value of key.
+func (this *DB[K, V]) Put(key K, value V) error {
+	return this.write("Put", func(mem *memtable[K, V], seq uint64) (err error) {
+		_, err = mem.points.Upsert(key, entry[V]{kind: kindPut, seq: seq, value: value})
+		return err
+	})
+}
+
+// Delete deletes key. The delete is kept as an entry until it has hidden the older values of the
+// key in all the tables.
+func (this *DB[K, V]) Delete(key K) error {
+	return this.write("Delete", func(mem *memtable[K, V], seq uint64) (err error) {
+		_, err = mem.points.Upsert(key, entry[V]{kind: kindDelete, seq: seq})
+		return err
+	})
+}
+
+// DeleteRange deletes the keys between key1 and key2, inclusive. The range delete is kept until
+// it has hidden the older values of the keys in all the tables, so it doesn't cost more than a
+// Delete, however many keys it deletes.
+func (this *DB[K, V]) DeleteRange(key1, key2 K) error {
+	return this.write("DeleteRange", func(mem *memtable[K, V], seq uint64) (err error) {
+		_, err = mem.ranges.Insert(key1, rangeDelete[K]{start: key1, end: key2, seq: seq})
+		return err
+	})
+}
+
+// write calls fn to write to the memtable with the next sequence number, and flushes the memtable
+// if it's full.
+func (this *DB[K, V]) write(name string, fn func(mem *memtable[K, V], seq uint64) error) error {
+	this.mutex.RLock()
+	if this.dir == "" {
+		this.mutex.RUnlock()
+		return errors.New("lsm/" + name + ": db is not open")
+	}
+
+	this.writeMutex.Lock()
+	err := fn(this.mem, this.seq+1)
+	if err == nil {
+		this.seq++
+	}
+	full := this.mem.count() >= this.memtableSize
+	this.writeMutex.Unlock()
+	this.mutex.RUnlock()
+
+	if err != nil {
+		return errors.New("lsm/" + name + ": error writing to the memtable; " + err.Error())
+	}
+
+	if full {
+		if err = this.flush(true); err != nil {
+			return errors.New("lsm/" + name + ": error flushing the memtable; " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value of key, and false if key isn't in the DB.
+func (this *DB[K, V]) Get(key K) (value V, ok bool, err error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if this.dir == "" {
+		return value, false, errors.New("lsm/Get: db is not open")
+	}
+
+	// The newest entry of the key is in the first source that has it, and only that source and
+	// newer ones can have newer range deletes
+	var e entry[V]
+	mems, m := this.memtables(), 0
+	for ; m < len(mems) && !ok; m++ {
+		if e, ok, err = mems[m].points.Get(key); err != nil {
+			return value, false, errors.New("lsm/Get: error reading the memtable; " + err.Error())
+		}
+	}
+
+	n := 0
+	if !ok {
+		data, err := this.keyCodec.Append(nil, key)
+		if err != nil {
+			return value, false, errors.New("lsm/Get: error encoding key; " + err.Error())
+		}
+
+		for ; n < len(this.tables) && !ok; n++ {
+			t := this.tables[n]
+			if e, ok, err = t.get(key, data); err != nil {
+				return value, false, errors.New("lsm/Get: error reading " + tableName(t.id) + "; " + err.Error())
+			}
+		}
+	}
+
+	if !ok || e.kind != kindPut {
+		return value, false, nil
+	}
+
+	for _, mem := range mems[:m] {
+		if deleted, err := mem.covers(this.compare, key, e.seq); err != nil {
+			return value, false, errors.New("lsm/Get: error reading the memtable; " + err.Error())
+		} else if deleted {
+			return value, false, nil
+		}
+	}
+
+	for _, t := range this.tables[:n] {
+		if t.covers(key, e.seq) {
+			return value, false, nil
+		}
+	}
+
+	return e.value, true, nil
+}
+
+// Flush writes the memtable to a table, and starts a new memtable, even if it isn't full.
+func (this *DB[K, V]) Flush() (err error) {
+	this.mutex.RLock()
+	open := this.dir != ""
+	this.mutex.RUnlock()
+
+	if !open {
+		return errors.New("lsm/Flush: db is not open")
+	}
+
+	if err = this.flush(false); err != nil {
+		return errors.New("lsm/Flush: " + err.Error())
+	}
+	return nil
+}
+
+// flush starts a new memtable, and writes the old one to a table, then removes it. If full is
+// true, the memtable is only flushed if it's full. Writes and reads go on while the table is
+// written, with the old memtable in imm. A memtable left in imm by a failed flush is flushed
+// first.
+func (this *DB[K, V]) flush(full bool) (err error) {
+	this.flushMutex.Lock()
+	defer this.flushMutex.Unlock()
+
+	for {
+		this.mutex.Lock()
+
+		// If the DB was closed in the meantime, the memtable is flushed by Open
+		if this.dir == "" {
+			this.mutex.Unlock()
+			return nil
+		}
+
+		retry := this.imm != nil
+		if !retry {
+			// Another write may have flushed the memtable in the meantime
+			if n := this.mem.count(); n == 0 || full && n < this.memtableSize {
+				this.mutex.Unlock()
+				return nil
+			}
+
+			// The new memtable exists before the table, so a crash in between leaves both
+			// memtables, and the old one is flushed again by Open
+			mem, err := this.openMemtable(this.nextID)
+			if err != nil {
+				this.mutex.Unlock()
+				return err
+			}
+
+			this.nextID++
+			this.mem, this.imm = mem, this.mem
+		}
+
+		imm, o := this.imm, this.options(nil)
+		this.mutex.Unlock()
+
+		t, err := this.writeMemtable(imm, o)
+
+		this.mutex.Lock()
+		if err == nil {
+			err = this.install(imm, t)
+		}
+		this.mutex.Unlock()
+
+		if err != nil || !retry {
+			return err
+		}
+	}
+}
+
+// writeMemtable writes mem to its table. mem must not take writes anymore.
+func (this *DB[K, V]) writeMemtable(mem *memtable[K, V], o tableOptions) (*table[K, V], error) {
+	return this.writeTable(mem.id, o, func(w *tableWriter[K, V]) error {
+		for key, e := range mem.points.All() {
+			if err := w.add(key, e); err != nil {
+				return err
+			}
+		}
+
+		for _, r := range mem.ranges.All() {
+			if err := w.addRange(r); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// install adds t, the table of the memtable in imm, to the tables, and removes the memtable. The
+// caller must hold the write lock.
+func (this *DB[K, V]) install(mem *memtable[K, V], t *table[K, V]) error {
+	tables := slices.Insert(slices.Clone(this.tables), 0, t)
+	if err := this.writeManifest(tables); err != nil {
+		t.obsolete.Store(true)
+		t.release()
+		return err
+	}
+
+	// Once the table is in the manifest, the memtable is removed by Open if removing it here fails
+	this.tables, this.imm = tables, nil
+	mem.close()
+	os.RemoveAll(filepath.Join(this.dir, memtableName(mem.id)))
+
+	this.wakeCompactor()
+	return nil
+}
+
+// tableOptions are the settings a table is written with, read while holding the lock.
+type tableOptions struct {
+	dir        string
+	blockSize  int
+	bitsPerKey int
+	throttle   func(n int) error
+}
+
+// options returns the settings for writing a table. The caller must hold the lock.
+func (this *DB[K, V]) options(throttle func(n int) error) tableOptions {
+	return tableOptions{
+		dir:        this.dir,
+		blockSize:  this.blockSize,
+		bitsPerKey: this.bitsPerKey,
+		throttle:   throttle,
+	}
+}
+
+// writeTable writes the table with the ID, with the entries fill adds to it, and opens it.
+func (this *DB[K, V]) writeTable(id uint64, o tableOptions, fill func(w *tableWriter[K, V]) error) (t *table[K, V], err error) {
+	path := filepath.Join(o.dir, tableName(id))
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	w := newTableWriter(file, this.keyCodec, this.valueCodec, o.blockSize, o.bitsPerKey, o.throttle)
+	if err = fill(w); err == nil {
+		err = w.finish()
+	}
+
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+
+	if err != nil {
+		file.Close()
+		os.Remove(path + ".tmp")
+		return nil, err
+	}
+
+	syncDir(o.dir)
+	return openTable(path, id, this.compare, this.keyCodec, this.valueCodec)
+}
+
+// syncDir makes sure files created or renamed in dir are found after a crash. Directories can't
+// be synced on all platforms, in which case there's nothing more to do.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
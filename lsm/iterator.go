@@ -0,0 +1,279 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsm
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/zentures/skiplist"
+)
+
+// source is the memtable or a table, as merged by merger. skiplist.Iterator implements it for the
+// memtable, and tableIterator for the tables.
+type source[K, V any] interface {
+	Next() bool
+	Key() K
+	Value() entry[V]
+	Err() error
+}
+
+// merger merges the entries of sources, which are sorted by key and ordered newest first, into the
+// newest entry of each key. Entries hidden by newer range deletes are left out, deletes are not.
+type merger[K, V any] struct {
+	compare func(k1, k2 K) int
+
+	// The sources, and whether each is on an entry
+	sources []source[K, V]
+	valid   []bool
+
+	// Range deletes that start after the current key, sorted by start key, and those that don't
+	ranges []rangeDelete[K]
+	active []rangeDelete[K]
+
+	key   K
+	entry entry[V]
+	err   error
+}
+
+func newMerger[K, V any](compare func(k1, k2 K) int, sources []source[K, V], ranges []rangeDelete[K]) *merger[K, V] {
+	slices.SortFunc(ranges, func(r1, r2 rangeDelete[K]) int {
+		return compare(r1.start, r2.start)
+	})
+
+	m := &merger[K, V]{
+		compare: compare,
+		sources: sources,
+		valid:   make([]bool, len(sources)),
+		ranges:  ranges,
+	}
+
+	for i := range sources {
+		m.advance(i)
+	}
+
+	return m
+}
+
+// next moves to the next key, and returns false if there are none left or an error occurred.
+func (this *merger[K, V]) next() bool {
+	for this.err == nil {
+		// The smallest key, from the newest source that has it. There are few sources, so
+		// looking at all of them is faster than keeping them in a heap.
+		m := -1
+		for i, s := range this.sources {
+			if this.valid[i] && (m < 0 || this.compare(s.Key(), this.sources[m].Key()) < 0) {
+				m = i
+			}
+		}
+
+		if m < 0 {
+			break
+		}
+
+		key, e := this.sources[m].Key(), this.sources[m].Value()
+
+		// The entries of the key in older sources are hidden by this one
+		for i, s := range this.sources {
+			if this.valid[i] && this.compare(s.Key(), key) == 0 {
+				this.advance(i)
+			}
+		}
+
+		if this.err == nil && !this.deleted(key, e.seq) {
+			this.key, this.entry = key, e
+			return true
+		}
+	}
+
+	return false
+}
+
+// advance moves source i to its next entry.
+func (this *merger[K, V]) advance(i int) {
+	if this.valid[i] = this.sources[i].Next(); !this.valid[i] && this.err == nil {
+		this.err = this.sources[i].Err()
+	}
+}
+
+// deleted returns true if a range delete written after seq includes key. It must be called with
+// keys in order.
+func (this *merger[K, V]) deleted(key K, seq uint64) bool {
+	for len(this.ranges) > 0 && this.compare(this.ranges[0].start, key) <= 0 {
+		this.active = append(this.active, this.ranges[0])
+		this.ranges = this.ranges[1:]
+	}
+
+	this.active = slices.DeleteFunc(this.active, func(r rangeDelete[K]) bool {
+		return this.compare(r.end, key) < 0
+	})
+
+	for _, r := range this.active {
+		if r.seq > seq {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Iterator iterates over the keys of a DB in order. It merges the memtables with the tables, and
+// returns the newest value of each key, leaving out deleted keys. The iterator reads from
+// snapshots of the memtables, and the tables at the time it was created, so it doesn't see later
+// writes. Close must be called when done with it.
+type Iterator[K, V any] struct {
+	merger *merger[K, V]
+	hi     skiplist.Bound[K]
+	done   bool
+
+	snaps  []*skiplist.Snapshot[K, entry[V]]
+	tables []*table[K, V]
+
+	key   K
+	value V
+}
+
+// SelectRange returns an iterator over the keys between key1 and key2, inclusive.
+func (this *DB[K, V]) SelectRange(key1, key2 K) (*Iterator[K, V], error) {
+	return this.selectBounds("SelectRange", skiplist.Inclusive(key1), skiplist.Inclusive(key2))
+}
+
+// SelectBounds returns an iterator over the keys between lo and hi. Use skiplist.Unbounded for
+// both to iterate over the whole DB.
+func (this *DB[K, V]) SelectBounds(lo, hi skiplist.Bound[K]) (*Iterator[K, V], error) {
+	return this.selectBounds("SelectBounds", lo, hi)
+}
+
+func (this *DB[K, V]) selectBounds(name string, lo, hi skiplist.Bound[K]) (iter *Iterator[K, V], err error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if this.dir == "" {
+		return nil, errors.New("lsm/" + name + ": db is not open")
+	}
+
+	iter = &Iterator[K, V]{
+		hi:     hi,
+		tables: slices.Clone(this.tables),
+	}
+
+	// The snapshots and the range deletes of the memtables are taken together, between writes
+	var ranges []rangeDelete[K]
+	this.writeMutex.Lock()
+	for _, mem := range this.memtables() {
+		iter.snaps = append(iter.snaps, mem.points.Snapshot())
+		ranges = append(ranges, mem.rangeDeletes()...)
+	}
+	this.writeMutex.Unlock()
+
+	for _, t := range iter.tables {
+		t.acquire()
+		ranges = append(ranges, t.ranges...)
+	}
+
+	var sources []source[K, V]
+	for _, snap := range iter.snaps {
+		mem, err := snap.SelectBounds(lo, hi)
+		if err != nil {
+			iter.Close()
+			return nil, errors.New("lsm/" + name + ": " + err.Error())
+		}
+		sources = append(sources, mem)
+	}
+
+	for _, t := range iter.tables {
+		sources = append(sources, newTableIterator(t, lo))
+	}
+
+	if iter.merger = newMerger(this.compare, sources, ranges); iter.merger.err != nil {
+		iter.Close()
+		return nil, errors.New("lsm/" + name + ": " + iter.merger.err.Error())
+	}
+
+	return iter, nil
+}
+
+// Next moves to the next key, and returns false if there are none left or an error occurred.
+func (this *Iterator[K, V]) Next() bool {
+	for !this.done && this.merger.next() {
+		m := this.merger
+		if !beforeHi(m.compare, this.hi, m.key) {
+			break
+		}
+
+		if m.entry.kind == kindPut {
+			this.key, this.value = m.key, m.entry.value
+			return true
+		}
+	}
+
+	this.done = true
+	return false
+}
+
+func (this *Iterator[K, V]) Key() K {
+	return this.key
+}
+
+func (this *Iterator[K, V]) Value() V {
+	return this.value
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (this *Iterator[K, V]) Err() error {
+	return this.merger.err
+}
+
+// Close releases the snapshots of the memtables and the tables.
+func (this *Iterator[K, V]) Close() (err error) {
+	if this.snaps == nil {
+		return nil
+	}
+
+	for _, snap := range this.snaps {
+		if serr := snap.Close(); err == nil {
+			err = serr
+		}
+	}
+
+	for _, t := range this.tables {
+		if terr := t.release(); err == nil {
+			err = terr
+		}
+	}
+
+	this.snaps, this.tables = nil, nil
+	return err
+}
+
+// afterLo returns true if key is after the lo bound of a range.
+func afterLo[K any](compare func(k1, k2 K) int, lo skiplist.Bound[K], key K) bool {
+	if lo.IsUnbounded() {
+		return true
+	}
+
+	c := compare(key, lo.Key())
+	return c > 0 || c == 0 && lo.IsInclusive()
+}
+
+// beforeHi returns true if key is before the hi bound of a range.
+func beforeHi[K any](compare func(k1, k2 K) int, hi skiplist.Bound[K], key K) bool {
+	if hi.IsUnbounded() {
+		return true
+	}
+
+	c := compare(key, hi.Key())
+	return c < 0 || c == 0 && hi.IsInclusive()
+}
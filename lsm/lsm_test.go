@@ -0,0 +1,555 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsm
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zentures/skiplist"
+)
+
+// check compares db to the model, with Get for each key up to n, and by iterating over all of it.
+func check(t *testing.T, db *DB[int, string], model map[int]string, n int) {
+	for k := 0; k < n; k++ {
+		v, ok, err := db.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mv, mok := model[k]; ok != mok || v != mv {
+			t.Fatalf("Get(%d) = %q, %v, expected %q, %v", k, v, ok, mv, mok)
+		}
+	}
+
+	var keys []int
+	for k := range model {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	iter, err := db.SelectBounds(skiplist.Unbounded[int](), skiplist.Unbounded[int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	i := 0
+	for ; iter.Next(); i++ {
+		if i >= len(keys) || iter.Key() != keys[i] || iter.Value() != model[keys[i]] {
+			t.Fatalf("unexpected key %d = %q at %d", iter.Key(), iter.Value(), i)
+		}
+	}
+
+	if iter.Err() != nil || i != len(keys) {
+		t.Fatal("iterator stopped early", iter.Err(), i, len(keys))
+	}
+}
+
+func TestDB(t *testing.T) {
+	for _, bits := range []int{0, 10} {
+		dir := t.TempDir()
+
+		open := func() *DB[int, string] {
+			db := NewOrdered[int, string]()
+			db.SetMemtableSize(100)
+			db.SetBlockSize(64)
+			db.SetBloomFilter(bits)
+			db.SetSyncPolicy(skiplist.SyncNever, 0)
+			db.SetCompactionTrigger(0)
+			if err := db.Open(dir); err != nil {
+				t.Fatal(err)
+			}
+			return db
+		}
+
+		db := open()
+		model := map[int]string{}
+
+		for i := 0; i < 3000; i++ {
+			k := rand.Intn(1000)
+			if rand.Intn(3) == 0 {
+				db.Delete(k)
+				delete(model, k)
+			} else {
+				v := strconv.Itoa(i)
+				db.Put(k, v)
+				model[k] = v
+			}
+		}
+
+		if len(db.tables) < 10 {
+			t.Fatal("expected the memtable to be flushed", len(db.tables))
+		}
+		check(t, db, model, 1000)
+
+		// Ranges start and end in the middle of blocks
+		iter, err := db.SelectBounds(skiplist.Exclusive(100), skiplist.Inclusive(200))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, expected []int
+		for iter.Next() {
+			got = append(got, iter.Key())
+		}
+		iter.Close()
+
+		for k := 101; k <= 200; k++ {
+			if _, ok := model[k]; ok {
+				expected = append(expected, k)
+			}
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Fatal("unexpected range", got, expected)
+		}
+
+		// Iterators don't see writes made after they were created
+		iter, _ = db.SelectRange(0, 1000)
+		db.Put(-1, "new")
+		db.Flush()
+		if iter.Next() && iter.Key() == -1 {
+			t.Fatal("iterator saw a later write")
+		}
+		iter.Close()
+		model[-1] = "new"
+
+		// The memtable is replayed from its log, and the tables are opened again
+		db.Put(-2, "unflushed")
+		model[-2] = "unflushed"
+		db.Close()
+
+		if _, _, err = db.Get(1); err == nil {
+			t.Fatal("expected error reading a closed db")
+		}
+
+		db = open()
+		check(t, db, model, 1000)
+		db.Close()
+	}
+}
+
+func TestDBRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	db := NewOrdered[int, string]()
+	db.Open(dir)
+	for i := 0; i < 10; i++ {
+		db.Put(i, strconv.Itoa(i))
+	}
+	db.Flush()
+	db.Put(10, "10")
+	mem := db.mem.id
+	db.Close()
+
+	// A crash in the middle of a flush leaves a newer memtable, and a temporary table file. A crash
+	// after a flush can leave the memtable of a table. A crash in the middle of a compaction can
+	// leave a table that isn't in the manifest.
+	os.Mkdir(filepath.Join(dir, memtableName(mem+1)), 0755)
+	os.WriteFile(filepath.Join(dir, tableName(mem)+".tmp"), []byte("partial"), 0644)
+	os.Mkdir(filepath.Join(dir, memtableName(mem-1)), 0755)
+	os.WriteFile(filepath.Join(dir, tableName(mem+2)), []byte("orphan"), 0644)
+
+	db = NewOrdered[int, string]()
+	if err := db.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.mem.id != mem+1 || len(db.tables) != 2 || db.tables[0].id != mem {
+		t.Fatal("unexpected memtable and tables after recovery", db.mem.id, len(db.tables))
+	}
+
+	model := map[int]string{}
+	for i := 0; i <= 10; i++ {
+		model[i] = strconv.Itoa(i)
+	}
+	check(t, db, model, 11)
+	db.Close()
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 4 {
+		t.Fatal("expected a manifest, 2 tables and a memtable", entries)
+	}
+
+	// Blocks are checked when they are read, the index and filter when the table is opened
+	path := filepath.Join(dir, tableName(mem))
+	data, _ := os.ReadFile(path)
+	data[0] ^= 1
+	os.WriteFile(path, data, 0644)
+
+	db.Open(dir)
+	if _, _, err := db.Get(10); err == nil {
+		t.Fatal("expected error reading a corrupt block")
+	}
+	db.Close()
+
+	data[len(data)-1] ^= 1
+	os.WriteFile(path, data, 0644)
+
+	if db.Open(dir) == nil {
+		t.Fatal("expected error opening a corrupt table")
+	}
+}
+
+func TestDBFlush(t *testing.T) {
+	dir := t.TempDir()
+
+	db := NewOrdered[int, string]()
+	db.SetCompactionTrigger(0)
+	db.Open(dir)
+
+	model := map[int]string{}
+	for i := 0; i < 100; i++ {
+		db.Put(i, strconv.Itoa(i))
+		model[i] = strconv.Itoa(i)
+	}
+	db.DeleteRange(10, 19)
+	for i := 10; i < 20; i++ {
+		delete(model, i)
+	}
+
+	// A table that can't be written leaves its memtable in imm, where reads still find it, while
+	// writes go to the new memtable
+	mem := db.mem.id
+	blocker := filepath.Join(dir, tableName(mem)+".tmp")
+	os.Mkdir(blocker, 0755)
+
+	if err := db.Flush(); err == nil {
+		t.Fatal("expected error writing the table")
+	}
+
+	if db.imm == nil || db.imm.id != mem || db.mem.id != mem+1 || len(db.tables) != 0 {
+		t.Fatal("expected the memtable to wait in imm", db.imm, db.mem.id, len(db.tables))
+	}
+
+	db.Put(5, "new")
+	db.Put(15, "15")
+	db.Delete(20)
+	model[5], model[15] = "new", "15"
+	delete(model, 20)
+	check(t, db, model, 101)
+
+	// The next flush writes the waiting memtable first
+	os.Remove(blocker)
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.imm != nil || len(db.tables) != 2 || db.tables[0].id != mem+1 || db.tables[1].id != mem {
+		t.Fatal("expected both memtables to be flushed", db.imm, len(db.tables))
+	}
+	check(t, db, model, 101)
+
+	// A memtable left in imm when the DB is closed is flushed by Open
+	db.Put(200, "200")
+	model[200] = "200"
+	mem = db.mem.id
+	os.Mkdir(filepath.Join(dir, tableName(mem)+".tmp"), 0755)
+	db.Flush()
+	db.Close()
+
+	if err := db.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.imm != nil || len(db.tables) != 3 || db.tables[0].id != mem {
+		t.Fatal("expected the memtable in imm to be flushed by Open", db.imm, len(db.tables))
+	}
+	check(t, db, model, 201)
+	db.Close()
+}
+
+func TestDBConcurrent(t *testing.T) {
+	db := NewOrdered[int, string]()
+	db.SetMemtableSize(50)
+	db.SetSyncPolicy(skiplist.SyncNever, 0)
+	db.Open(t.TempDir())
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				db.Put(i*4+g, strconv.Itoa(i))
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, _, err := db.Get(rand.Intn(2000)); err != nil {
+					t.Error(err)
+				}
+
+				iter, err := db.SelectRange(0, 100)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for iter.Next() {
+				}
+				iter.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	model := map[int]string{}
+	for g := 0; g < 4; g++ {
+		for i := 0; i < 500; i++ {
+			model[i*4+g] = strconv.Itoa(i)
+		}
+	}
+	check(t, db, model, 2000)
+}
+
+func TestDBDeleteRange(t *testing.T) {
+	dir := t.TempDir()
+
+	db := NewOrdered[int, string]()
+	db.SetMemtableSize(100)
+	db.SetBlockSize(64)
+	db.SetSyncPolicy(skiplist.SyncNever, 0)
+	db.SetCompactionTrigger(0)
+	db.Open(dir)
+
+	// Range deletes hide older writes in the memtable and the tables, but not newer ones
+	model := map[int]string{}
+	for i := 0; i < 3000; i++ {
+		k := rand.Intn(1000)
+		switch rand.Intn(20) {
+		case 0:
+			n := rand.Intn(50)
+			db.DeleteRange(k, k+n)
+			for j := k; j <= k+n; j++ {
+				delete(model, j)
+			}
+
+		case 1:
+			db.DeleteRange(k, k-1)
+
+		default:
+			v := strconv.Itoa(i)
+			db.Put(k, v)
+			model[k] = v
+		}
+	}
+	check(t, db, model, 1000)
+
+	// Compacting drops the deleted entries, and the range deletes along with them
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.tables) != 1 || db.tables[0].count != len(model) || len(db.tables[0].ranges) != 0 {
+		t.Fatal("expected a single table with the live keys", len(db.tables), db.tables[0].count, len(model))
+	}
+	check(t, db, model, 1000)
+
+	// Range deletes are in the log of the memtable too
+	db.DeleteRange(100, 199)
+	for k := 100; k <= 199; k++ {
+		delete(model, k)
+	}
+	db.Close()
+
+	db.Open(dir)
+	check(t, db, model, 1000)
+	db.Close()
+}
+
+func TestDBCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	db := NewOrdered[int, string]()
+	db.SetMemtableSize(50)
+	db.SetBlockSize(64)
+	db.SetSyncPolicy(skiplist.SyncNever, 0)
+	if err := db.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	model := map[int]string{}
+	for i := 0; i < 5000; i++ {
+		k := rand.Intn(1000)
+		switch rand.Intn(10) {
+		case 0:
+			db.Delete(k)
+			delete(model, k)
+
+		case 1:
+			db.DeleteRange(k, k+5)
+			for j := k; j <= k+5; j++ {
+				delete(model, j)
+			}
+
+		default:
+			v := strconv.Itoa(i)
+			db.Put(k, v)
+			model[k] = v
+		}
+	}
+
+	// Wait for the background compactions
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		db.mutex.RLock()
+		tables, flushes, busy := len(db.tables), db.nextID, db.pick() != nil
+		db.mutex.RUnlock()
+
+		if !busy {
+			if tables >= 10 || flushes < 50 {
+				t.Fatal("expected the tables to be compacted", tables, flushes)
+			}
+			break
+		}
+
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("compactions didn't finish", tables)
+		}
+	}
+	check(t, db, model, 1000)
+
+	countTables := func() (n int) {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".sst") {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := countTables(); n != len(db.tables) {
+		t.Fatal("compacted tables weren't deleted", n, len(db.tables))
+	}
+
+	// Tables replaced by a compaction are kept until the iterators reading them are closed
+	iter, _ := db.SelectBounds(skiplist.Unbounded[int](), skiplist.Unbounded[int]())
+	expected := len(model)
+	db.Flush()
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.tables) != 1 || countTables() < 2 {
+		t.Fatal("unexpected tables after compacting", len(db.tables), countTables())
+	}
+
+	n := 0
+	for iter.Next() {
+		if iter.Value() != model[iter.Key()] {
+			t.Fatal("unexpected value", iter.Key(), iter.Value())
+		}
+		n++
+	}
+
+	if iter.Err() != nil || n != expected {
+		t.Fatal("iterator failed after compaction", iter.Err(), n, expected)
+	}
+	iter.Close()
+
+	if countTables() != 1 {
+		t.Fatal("compacted tables weren't deleted after closing the iterator", countTables())
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Open(dir)
+	check(t, db, model, 1000)
+
+	// A throttled compaction is stopped by Close, and leaves the tables as they were
+	db.SetMemtableSize(1000)
+	db.SetCompactionTrigger(0)
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 200; i++ {
+			db.Put(i, "throttled"+strconv.Itoa(j))
+			model[i] = "throttled" + strconv.Itoa(j)
+		}
+		db.Flush()
+	}
+
+	db.SetCompactionRate(100)
+	db.SetCompactionTrigger(2)
+	for start := time.Now(); db.compactMutex.TryLock(); time.Sleep(time.Millisecond) {
+		db.compactMutex.Unlock()
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("compaction didn't start")
+		}
+	}
+
+	start := time.Now()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("Close waited for a throttled compaction", time.Since(start))
+	}
+
+	db.SetCompactionTrigger(0)
+	db.Open(dir)
+	if len(db.tables) != 3 {
+		t.Fatal("expected the throttled compaction to be stopped", len(db.tables))
+	}
+	check(t, db, model, 1000)
+	db.Close()
+}
+
+func TestBloom(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 10000; i++ {
+		hashes = append(hashes, hash([]byte(strconv.Itoa(i))))
+	}
+
+	b, err := decodeBloom(newBloom(hashes, 10).encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range hashes {
+		if !b.contains(h) {
+			t.Fatal("bloom filter is missing a key")
+		}
+	}
+
+	positives := 0
+	for i := 10000; i < 20000; i++ {
+		if b.contains(hash([]byte(strconv.Itoa(i)))) {
+			positives++
+		}
+	}
+
+	if positives > 300 {
+		t.Fatal("too many false positives", positives)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+
+	"github.com/zentures/skiplist"
+)
+
+// Entry kinds
+const (
+	kindPut    byte = 1
+	kindDelete byte = 2
+)
+
+// entry is the value of a key in the memtable or a table: either a value, or a delete that hides
+// the older values of the key. seq orders the writes to the DB.
+type entry[V any] struct {
+	kind  byte
+	seq   uint64
+	value V
+}
+
+// rangeDelete hides the values of the keys between start and end, inclusive, written before seq.
+type rangeDelete[K any] struct {
+	start K
+	end   K
+	seq   uint64
+}
+
+// memtable is the write buffer of a DB. It has a skiplist of the newest entry of each key, and a
+// skiplist of the range deletes by their start keys, in the directory of the memtable, each with
+// its own write-ahead log.
+type memtable[K, V any] struct {
+	id     uint64
+	points *skiplist.Skiplist[K, entry[V]]
+	ranges *skiplist.Skiplist[K, rangeDelete[K]]
+}
+
+// openMemtable opens the memtable with the ID, replaying its write-ahead logs.
+func (this *DB[K, V]) openMemtable(id uint64) (mem *memtable[K, V], err error) {
+	dir := filepath.Join(this.dir, memtableName(id))
+	mem = &memtable[K, V]{
+		id:     id,
+		points: skiplist.NewFunc[K, entry[V]](this.compare),
+		ranges: skiplist.NewFunc[K, rangeDelete[K]](this.compare),
+	}
+
+	mem.points.SetUnique(true)
+	mem.points.SetCodecs(this.keyCodec, entryCodec[V]{this.valueCodec})
+	mem.points.SetSyncPolicy(this.syncPolicy, this.syncInterval)
+	mem.ranges.SetCodecs(this.keyCodec, rangeCodec[K]{this.keyCodec})
+	mem.ranges.SetSyncPolicy(this.syncPolicy, this.syncInterval)
+
+	if err = mem.points.Open(dir); err != nil {
+		return nil, err
+	}
+
+	if err = mem.ranges.Open(filepath.Join(dir, "ranges")); err != nil {
+		mem.points.Close()
+		return nil, err
+	}
+
+	return mem, nil
+}
+
+// count returns the number of entries and range deletes in the memtable.
+func (this *memtable[K, V]) count() int {
+	return this.points.Count() + this.ranges.Count()
+}
+
+// maxSeq returns the greatest sequence number in the memtable.
+func (this *memtable[K, V]) maxSeq() (seq uint64) {
+	for _, e := range this.points.All() {
+		seq = max(seq, e.seq)
+	}
+
+	for _, r := range this.ranges.All() {
+		seq = max(seq, r.seq)
+	}
+
+	return seq
+}
+
+// rangeDeletes returns the range deletes of the memtable.
+func (this *memtable[K, V]) rangeDeletes() (ranges []rangeDelete[K]) {
+	for _, r := range this.ranges.All() {
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+// covers returns true if a range delete written after seq includes key. The range deletes that
+// start at or before key are checked one by one, which is fine as long as there are few of them.
+func (this *memtable[K, V]) covers(compare func(k1, k2 K) int, key K, seq uint64) (bool, error) {
+	iter, err := this.ranges.SelectBounds(skiplist.Unbounded[K](), skiplist.Inclusive(key))
+	if err != nil {
+		return false, err
+	}
+
+	for iter.Next() {
+		if r := iter.Value(); r.seq > seq && compare(r.end, key) >= 0 {
+			return true, nil
+		}
+	}
+
+	return false, iter.Err()
+}
+
+func (this *memtable[K, V]) close() error {
+	err := this.points.Close()
+	if rerr := this.ranges.Close(); err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+// entryCodec encodes the entries of the memtable for its write-ahead log, as the kind, the
+// sequence number as a uvarint, and the value of puts.
+type entryCodec[V any] struct {
+	values skiplist.Codec[V]
+}
+
+func (this entryCodec[V]) Append(buf []byte, e entry[V]) ([]byte, error) {
+	buf = binary.AppendUvarint(append(buf, e.kind), e.seq)
+	if e.kind != kindPut {
+		return buf, nil
+	}
+
+	return this.values.Append(buf, e.value)
+}
+
+func (this entryCodec[V]) Decode(data []byte) (e entry[V], err error) {
+	r := &reader{data: data}
+	if e.kind, e.seq = r.byte(), r.uvarint(); r.err != nil || e.kind != kindPut && e.kind != kindDelete {
+		return e, errors.New("lsm: invalid entry")
+	}
+
+	if e.kind == kindPut {
+		e.value, err = this.values.Decode(r.data)
+	}
+	return e, err
+}
+
+// rangeCodec encodes range deletes for the write-ahead log of the memtable, as the sequence
+// number as a uvarint, the start key prefixed with its length, and the end key.
+type rangeCodec[K any] struct {
+	keys skiplist.Codec[K]
+}
+
+func (this rangeCodec[K]) Append(buf []byte, r rangeDelete[K]) (_ []byte, err error) {
+	start, err := this.keys.Append(nil, r.start)
+	if err != nil {
+		return nil, err
+	}
+
+	buf = binary.AppendUvarint(buf, r.seq)
+	buf = binary.AppendUvarint(buf, uint64(len(start)))
+	return this.keys.Append(append(buf, start...), r.end)
+}
+
+func (this rangeCodec[K]) Decode(data []byte) (r rangeDelete[K], err error) {
+	rd := &reader{data: data}
+	r.seq = rd.uvarint()
+	start := rd.bytes()
+	if rd.err != nil {
+		return r, errors.New("lsm: invalid range delete")
+	}
+
+	if r.start, err = this.keys.Decode(start); err != nil {
+		return r, err
+	}
+
+	r.end, err = this.keys.Decode(rd.data)
+	return r, err
+}
@@ -0,0 +1,627 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/zentures/skiplist"
+)
+
+// A table is an immutable file with the entries of a flushed memtable, or of merged tables, in key
+// order. It has
+//
+//	data blocks  entries of about the block size, followed by their CRC-32C
+//	index        the number of blocks, then the last key, offset and length of each block,
+//	             followed by the CRC-32C of the index
+//	filter       the bloom filter of the keys, empty if there's none, followed by its CRC-32C
+//	ranges       the number of range deletes, then the start key, end key and sequence number of
+//	             each, followed by the CRC-32C of the range deletes
+//	footer       the offset and length of the index, the filter and the range deletes, the number
+//	             of entries and the greatest sequence number, as little endian uint64s, followed
+//	             by the magic "SKSST002"
+//
+// An entry is a kind byte, the sequence number, the key and, unless the entry is a delete, the
+// value. Keys and values are encoded with the DB's codecs, and prefixed with their length as a
+// uvarint. Sequence numbers, lengths and offsets are uvarints too, and block lengths include the
+// CRC.
+const (
+	tableMagic      = "SKSST002"
+	tableFooterSize = 8*8 + len(tableMagic)
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// handle is the position of a data block in a table, and the last key in it.
+type handle[K any] struct {
+	last   K
+	offset int64
+	length int64
+}
+
+type table[K, V any] struct {
+	id   uint64
+	path string
+	file *os.File
+	size int64
+
+	compare    func(k1, k2 K) int
+	keyCodec   skiplist.Codec[K]
+	valueCodec skiplist.Codec[V]
+
+	index  []handle[K]
+	filter *bloom
+	ranges []rangeDelete[K]
+	count  int
+	maxSeq uint64
+
+	// The DB and each iterator reading the table hold a reference to it. The file is closed when
+	// the last one is released, and deleted if a compaction replaced the table.
+	refs     atomic.Int32
+	obsolete atomic.Bool
+}
+
+// tableWriter writes the entries added to it, in order, to a table file.
+type tableWriter[K, V any] struct {
+	file *os.File
+	bw   *bufio.Writer
+
+	keyCodec   skiplist.Codec[K]
+	valueCodec skiplist.Codec[V]
+
+	blockSize  int
+	bitsPerKey int
+
+	// throttle, if not nil, is called with the size of each block written, and stops the writer
+	// if it returns an error
+	throttle func(n int) error
+
+	// Current block, and the offset where it starts
+	block  []byte
+	offset int64
+
+	// Encoded last key of the current block, and the index of the blocks written so far
+	last   []byte
+	index  []byte
+	blocks int
+
+	// Hashes of the keys, for the bloom filter
+	hashes []uint64
+	count  int
+	maxSeq uint64
+
+	// Encoded range deletes
+	ranges  []byte
+	nranges int
+
+	scratch []byte
+}
+
+func newTableWriter[K, V any](file *os.File, keyCodec skiplist.Codec[K], valueCodec skiplist.Codec[V], blockSize, bitsPerKey int, throttle func(n int) error) *tableWriter[K, V] {
+	return &tableWriter[K, V]{
+		file:       file,
+		bw:         bufio.NewWriter(file),
+		keyCodec:   keyCodec,
+		valueCodec: valueCodec,
+		blockSize:  blockSize,
+		bitsPerKey: bitsPerKey,
+		throttle:   throttle,
+	}
+}
+
+// add appends an entry to the table. Entries must be added in key order.
+func (this *tableWriter[K, V]) add(key K, e entry[V]) (err error) {
+	if this.scratch, err = this.keyCodec.Append(this.scratch[:0], key); err != nil {
+		return errors.New("error encoding key; " + err.Error())
+	}
+
+	this.block = append(this.block, e.kind)
+	this.block = binary.AppendUvarint(this.block, e.seq)
+	this.block = binary.AppendUvarint(this.block, uint64(len(this.scratch)))
+	this.block = append(this.block, this.scratch...)
+	this.last = append(this.last[:0], this.scratch...)
+
+	if this.bitsPerKey > 0 {
+		this.hashes = append(this.hashes, hash(this.scratch))
+	}
+
+	if e.kind == kindPut {
+		if this.scratch, err = this.valueCodec.Append(this.scratch[:0], e.value); err != nil {
+			return errors.New("error encoding value; " + err.Error())
+		}
+
+		this.block = binary.AppendUvarint(this.block, uint64(len(this.scratch)))
+		this.block = append(this.block, this.scratch...)
+	}
+	this.count++
+	this.maxSeq = max(this.maxSeq, e.seq)
+
+	if len(this.block) >= this.blockSize {
+		return this.finishBlock()
+	}
+	return nil
+}
+
+// addRange adds a range delete to the table, in any order.
+func (this *tableWriter[K, V]) addRange(r rangeDelete[K]) (err error) {
+	for _, key := range []K{r.start, r.end} {
+		if this.scratch, err = this.keyCodec.Append(this.scratch[:0], key); err != nil {
+			return errors.New("error encoding key; " + err.Error())
+		}
+
+		this.ranges = binary.AppendUvarint(this.ranges, uint64(len(this.scratch)))
+		this.ranges = append(this.ranges, this.scratch...)
+	}
+
+	this.ranges = binary.AppendUvarint(this.ranges, r.seq)
+	this.nranges++
+	this.maxSeq = max(this.maxSeq, r.seq)
+
+	return nil
+}
+
+// finishBlock writes the current block, and adds it to the index.
+func (this *tableWriter[K, V]) finishBlock() error {
+	this.block = binary.LittleEndian.AppendUint32(this.block, crc32.Checksum(this.block, crcTable))
+	if _, err := this.bw.Write(this.block); err != nil {
+		return err
+	}
+
+	this.index = binary.AppendUvarint(this.index, uint64(len(this.last)))
+	this.index = append(this.index, this.last...)
+	this.index = binary.AppendUvarint(this.index, uint64(this.offset))
+	this.index = binary.AppendUvarint(this.index, uint64(len(this.block)))
+	this.blocks++
+
+	this.offset += int64(len(this.block))
+	n := len(this.block)
+	this.block = this.block[:0]
+
+	if this.throttle != nil {
+		return this.throttle(n)
+	}
+	return nil
+}
+
+// finish writes the rest of the table, and syncs and closes the file.
+func (this *tableWriter[K, V]) finish() (err error) {
+	if len(this.block) > 0 {
+		if err = this.finishBlock(); err != nil {
+			return err
+		}
+	}
+
+	var footer []byte
+
+	// Index, filter and range deletes, each followed by its CRC
+	index := binary.AppendUvarint(nil, uint64(this.blocks))
+	index = append(index, this.index...)
+
+	var filter []byte
+	if this.bitsPerKey > 0 {
+		filter = newBloom(this.hashes, this.bitsPerKey).encode()
+	}
+
+	ranges := binary.AppendUvarint(nil, uint64(this.nranges))
+	ranges = append(ranges, this.ranges...)
+
+	for _, section := range [][]byte{index, filter, ranges} {
+		section = binary.LittleEndian.AppendUint32(section, crc32.Checksum(section, crcTable))
+		if _, err = this.bw.Write(section); err != nil {
+			return err
+		}
+
+		footer = binary.LittleEndian.AppendUint64(footer, uint64(this.offset))
+		footer = binary.LittleEndian.AppendUint64(footer, uint64(len(section)))
+		this.offset += int64(len(section))
+	}
+
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(this.count))
+	footer = binary.LittleEndian.AppendUint64(footer, this.maxSeq)
+	footer = append(footer, tableMagic...)
+	if _, err = this.bw.Write(footer); err != nil {
+		return err
+	}
+
+	if err = this.bw.Flush(); err != nil {
+		return err
+	}
+
+	if err = this.file.Sync(); err != nil {
+		return err
+	}
+
+	return this.file.Close()
+}
+
+// openTable opens the table file at path, and reads its index and filter.
+func openTable[K, V any](path string, id uint64, compare func(k1, k2 K) int, keyCodec skiplist.Codec[K], valueCodec skiplist.Codec[V]) (t *table[K, V], err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			file.Close()
+		}
+	}()
+
+	t = &table[K, V]{
+		id:         id,
+		path:       path,
+		file:       file,
+		compare:    compare,
+		keyCodec:   keyCodec,
+		valueCodec: valueCodec,
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	} else if info.Size() < int64(tableFooterSize) {
+		return nil, errors.New("table is too short")
+	}
+
+	footer := make([]byte, tableFooterSize)
+	if _, err = file.ReadAt(footer, info.Size()-int64(tableFooterSize)); err != nil {
+		return nil, err
+	} else if string(footer[8*8:]) != tableMagic {
+		return nil, errors.New("file is not a table")
+	}
+
+	var fields [8]int64
+	for i := range fields {
+		fields[i] = int64(binary.LittleEndian.Uint64(footer[i*8:]))
+	}
+	t.size, t.count, t.maxSeq = info.Size(), int(fields[6]), uint64(fields[7])
+
+	index, err := t.read(fields[0], fields[1])
+	if err != nil {
+		return nil, errors.New("error reading index; " + err.Error())
+	}
+
+	if err = t.readIndex(index); err != nil {
+		return nil, errors.New("error reading index; " + err.Error())
+	}
+
+	filter, err := t.read(fields[2], fields[3])
+	if err != nil {
+		return nil, errors.New("error reading filter; " + err.Error())
+	}
+
+	if len(filter) > 0 {
+		if t.filter, err = decodeBloom(filter); err != nil {
+			return nil, errors.New("error reading filter; " + err.Error())
+		}
+	}
+
+	ranges, err := t.read(fields[4], fields[5])
+	if err == nil {
+		err = t.readRanges(ranges)
+	}
+
+	if err != nil {
+		return nil, errors.New("error reading range deletes; " + err.Error())
+	}
+
+	t.refs.Store(1)
+	return t, nil
+}
+
+// read reads the section of the table at offset, and checks its CRC, which is left out.
+func (this *table[K, V]) read(offset, length int64) ([]byte, error) {
+	if length < 4 || offset < 0 || length > 1<<32 {
+		return nil, errors.New("invalid section at offset " + strconv.FormatInt(offset, 10))
+	}
+
+	data := make([]byte, length)
+	if _, err := this.file.ReadAt(data, offset); err != nil {
+		if err == io.EOF {
+			err = errors.New("section at offset " + strconv.FormatInt(offset, 10) + " is past the end")
+		}
+		return nil, err
+	}
+
+	data, crc := data[:length-4], binary.LittleEndian.Uint32(data[length-4:])
+	if crc32.Checksum(data, crcTable) != crc {
+		return nil, errors.New("bad checksum at offset " + strconv.FormatInt(offset, 10))
+	}
+
+	return data, nil
+}
+
+func (this *table[K, V]) readIndex(data []byte) (err error) {
+	r := &reader{data: data}
+	n := r.uvarint()
+
+	for ; r.err == nil && n > 0; n-- {
+		var h handle[K]
+		if h.last, err = this.keyCodec.Decode(r.bytes()); err != nil {
+			return errors.New("error decoding key; " + err.Error())
+		}
+
+		h.offset, h.length = int64(r.uvarint()), int64(r.uvarint())
+		this.index = append(this.index, h)
+	}
+
+	if r.err != nil || len(r.data) > 0 {
+		return errors.New("invalid index")
+	}
+	return nil
+}
+
+func (this *table[K, V]) readRanges(data []byte) (err error) {
+	r := &reader{data: data}
+	n := r.uvarint()
+
+	for ; r.err == nil && n > 0; n-- {
+		var rd rangeDelete[K]
+		if rd.start, err = this.keyCodec.Decode(r.bytes()); err != nil {
+			return errors.New("error decoding key; " + err.Error())
+		}
+
+		if rd.end, err = this.keyCodec.Decode(r.bytes()); err != nil {
+			return errors.New("error decoding key; " + err.Error())
+		}
+
+		rd.seq = r.uvarint()
+		this.ranges = append(this.ranges, rd)
+	}
+
+	if r.err != nil || len(r.data) > 0 {
+		return errors.New("invalid range deletes")
+	}
+	return nil
+}
+
+// covers returns true if a range delete in the table written after seq includes key.
+func (this *table[K, V]) covers(key K, seq uint64) bool {
+	for _, r := range this.ranges {
+		if r.seq > seq && this.compare(r.start, key) <= 0 && this.compare(r.end, key) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// search returns the first block whose last key is greater than or equal to key, or, if after is
+// true, greater than key. It returns len(this.index) if there's no such block.
+func (this *table[K, V]) search(key K, after bool) int {
+	return sort.Search(len(this.index), func(i int) bool {
+		c := this.compare(this.index[i].last, key)
+		return c > 0 || c == 0 && !after
+	})
+}
+
+// get returns the entry for key, and false if the table doesn't have key. data is the encoded
+// key, which is used to check the bloom filter before any block is read.
+func (this *table[K, V]) get(key K, data []byte) (e entry[V], ok bool, err error) {
+	if this.filter != nil && !this.filter.contains(hash(data)) {
+		return
+	}
+
+	i := this.search(key, false)
+	if i == len(this.index) {
+		return
+	}
+
+	block, err := this.read(this.index[i].offset, this.index[i].length)
+	if err != nil {
+		return
+	}
+
+	r := &reader{data: block}
+	for len(r.data) > 0 {
+		var k K
+		if k, e, err = this.decodeEntry(r); err != nil {
+			return
+		}
+
+		if c := this.compare(k, key); c == 0 {
+			return e, true, nil
+		} else if c > 0 {
+			break
+		}
+	}
+
+	return entry[V]{}, false, nil
+}
+
+// decodeEntry decodes the next entry in a block from r.
+func (this *table[K, V]) decodeEntry(r *reader) (key K, e entry[V], err error) {
+	e.kind, e.seq = r.byte(), r.uvarint()
+	if e.kind != kindPut && e.kind != kindDelete {
+		r.fail()
+	}
+
+	data := r.bytes()
+	if r.err != nil {
+		return key, e, errors.New("invalid block")
+	}
+
+	if key, err = this.keyCodec.Decode(data); err != nil {
+		return key, e, errors.New("error decoding key; " + err.Error())
+	}
+
+	if e.kind == kindPut {
+		if data = r.bytes(); r.err != nil {
+			return key, e, errors.New("invalid block")
+		}
+
+		if e.value, err = this.valueCodec.Decode(data); err != nil {
+			return key, e, errors.New("error decoding value; " + err.Error())
+		}
+	}
+
+	return key, e, nil
+}
+
+func (this *table[K, V]) acquire() {
+	this.refs.Add(1)
+}
+
+// release releases a reference to the table, closing it once there are none left.
+func (this *table[K, V]) release() (err error) {
+	if this.refs.Add(-1) > 0 {
+		return nil
+	}
+
+	err = this.file.Close()
+	if this.obsolete.Load() {
+		if rerr := os.Remove(this.path); err == nil {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+// tableIterator iterates over the entries of a table, reading one block at a time.
+type tableIterator[K, V any] struct {
+	table *table[K, V]
+
+	// Current block, and what's left of it
+	block int
+	r     reader
+
+	// pending is true if the iterator is on an entry that Next hasn't returned yet
+	pending bool
+
+	key   K
+	entry entry[V]
+	err   error
+}
+
+// newTableIterator returns an iterator over the entries of t starting at lo.
+func newTableIterator[K, V any](t *table[K, V], lo skiplist.Bound[K]) *tableIterator[K, V] {
+	iter := &tableIterator[K, V]{table: t, block: -1}
+	if !lo.IsUnbounded() {
+		iter.block = t.search(lo.Key(), lo.IsExclusive()) - 1
+	}
+
+	// Skip the entries before lo in the first block
+	for iter.next() {
+		if afterLo(t.compare, lo, iter.key) {
+			iter.pending = true
+			break
+		}
+	}
+
+	return iter
+}
+
+// Next moves to the next entry, and returns false if there are none left or an error occurred.
+func (this *tableIterator[K, V]) Next() bool {
+	if this.pending {
+		this.pending = false
+		return true
+	}
+
+	return this.next()
+}
+
+func (this *tableIterator[K, V]) next() bool {
+	if this.err != nil {
+		return false
+	}
+
+	for len(this.r.data) == 0 {
+		if this.block+1 >= len(this.table.index) {
+			return false
+		}
+		this.block++
+
+		h := this.table.index[this.block]
+		data, err := this.table.read(h.offset, h.length)
+		if err != nil {
+			this.err = err
+			return false
+		}
+		this.r = reader{data: data}
+	}
+
+	if this.key, this.entry, this.err = this.table.decodeEntry(&this.r); this.err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (this *tableIterator[K, V]) Key() K {
+	return this.key
+}
+
+func (this *tableIterator[K, V]) Value() entry[V] {
+	return this.entry
+}
+
+func (this *tableIterator[K, V]) Err() error {
+	return this.err
+}
+
+// reader reads the fields of a block or index. Once a read runs past the end of the data, err
+// is set, and all reads return zero values.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (this *reader) fail() {
+	this.data, this.err = nil, errors.New("invalid data")
+}
+
+func (this *reader) uvarint() uint64 {
+	u, n := binary.Uvarint(this.data)
+	if n <= 0 {
+		this.fail()
+		return 0
+	}
+
+	this.data = this.data[n:]
+	return u
+}
+
+func (this *reader) byte() byte {
+	if len(this.data) == 0 {
+		this.fail()
+		return 0
+	}
+
+	b := this.data[0]
+	this.data = this.data[1:]
+	return b
+}
+
+func (this *reader) bytes() []byte {
+	n := this.uvarint()
+	if n > uint64(len(this.data)) {
+		this.fail()
+		return nil
+	}
+
+	b := this.data[:n]
+	this.data = this.data[n:]
+	return b
+}
@@ -0,0 +1,804 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// The mapped format is a skiplist laid out in a file, with offsets from the start of the file
+// instead of pointers, so it can be searched in place once the file is mapped into memory. It
+// starts with a header:
+//
+//	magic       "SKMM"
+//	version     uint32
+//	level       uint32, the number of levels of the head node
+//	count       uint64
+//	comparator  string, the name of the comparator function
+//	key type    string
+//	value type  string
+//	checksum    uint32, the CRC-32C of the header
+//
+// followed by the head node, and the count nodes in list order. A node is:
+//
+//	level       byte
+//	key length  uint32
+//	value length uint32
+//	next        level uint64 offsets, the next node at each level, or 0 at the end of the level
+//	key         encoded with the list's key codec
+//	value       encoded with the list's value codec
+//
+// The head node has no key or value. Numbers are little endian, strings are prefixed with their
+// length as a uint32, and next offsets always point forward.
+const (
+	mappedMagic      = "SKMM"
+	mappedVersion    = 1
+	mappedHeaderSize = 4 + 4 + 4 + 8
+	mappedNodeSize   = 1 + 4 + 4
+)
+
+// WriteMapped writes the list to the file at path, in a pointer-free layout that OpenMapped maps
+// into memory. The file is replaced atomically, so processes that have the old file mapped keep
+// reading it until they open it again. Tombstones kept for snapshots are not included.
+func (this *Skiplist[K, V]) WriteMapped(path string) (err error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return errors.New("skiplist/WriteMapped: " + err.Error())
+	}
+
+	if err = this.writeMapped(file); err == nil {
+		err = file.Sync()
+	}
+
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return errors.New("skiplist/WriteMapped: " + err.Error())
+	}
+
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// writeMapped writes the live nodes of the list to file. The caller must hold the lock.
+func (this *Skiplist[K, V]) writeMapped(file *os.File) (err error) {
+	header := []byte(mappedMagic)
+	header = binary.LittleEndian.AppendUint32(header, mappedVersion)
+
+	// The offset of each node depends on the size of the nodes before it, and its next offsets on
+	// the offsets of the nodes after it, so the keys and values are encoded twice: once to lay
+	// out the nodes, and once to write them
+	var (
+		nodes   []*node[K, V]
+		offsets []int64
+		data    []byte
+		level   = 1
+	)
+
+	for p := this.skip(this.headNode.next[0], current, false); p != nil; p = this.skip(p.next[0], current, false) {
+		nodes = append(nodes, p)
+		level = max(level, len(p.next))
+	}
+
+	header = binary.LittleEndian.AppendUint32(header, uint32(level))
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(nodes)))
+	for _, name := range []string{this.compareName, reflect.TypeFor[K]().String(), reflect.TypeFor[V]().String()} {
+		header = binary.LittleEndian.AppendUint32(header, uint32(len(name)))
+		header = append(header, name...)
+	}
+	header = binary.LittleEndian.AppendUint32(header, crc32.Checksum(header, crcTable))
+
+	offset := int64(len(header)) + mappedNodeSize + 8*int64(level)
+	for _, p := range nodes {
+		offsets = append(offsets, offset)
+
+		size := int64(mappedNodeSize + 8*len(p.next))
+		if data, err = this.keyCodec.Append(data[:0], p.key); err != nil {
+			return errors.New("error encoding key; " + err.Error())
+		}
+		size += int64(len(data))
+
+		if data, err = this.valueCodec.Append(data[:0], p.value); err != nil {
+			return errors.New("error encoding value; " + err.Error())
+		}
+		size += int64(len(data))
+
+		offset += size
+	}
+
+	// next[l] is the offset of the next node at level l, walking back from the end
+	next := make([]int64, level)
+	links := make([][]int64, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		links[i] = slices.Clone(next[:len(nodes[i].next)])
+		for l := range links[i] {
+			next[l] = offsets[i]
+		}
+	}
+
+	bw := bufio.NewWriter(file)
+	bw.Write(header)
+
+	var buf []byte
+	writeNode := func(links []int64, key, value []byte) {
+		buf = append(buf[:0], byte(len(links)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value)))
+		for _, off := range links {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(off))
+		}
+		buf = append(buf, key...)
+		buf = append(buf, value...)
+		bw.Write(buf)
+	}
+
+	writeNode(next, nil, nil)
+
+	var key []byte
+	for i, p := range nodes {
+		if key, err = this.keyCodec.Append(key[:0], p.key); err != nil {
+			return errors.New("error encoding key; " + err.Error())
+		}
+
+		if data, err = this.valueCodec.Append(data[:0], p.value); err != nil {
+			return errors.New("error encoding value; " + err.Error())
+		}
+
+		if len(key) > maxDataLength || len(data) > maxDataLength {
+			return errors.New("key or value is too large")
+		}
+
+		end := offset
+		if i+1 < len(nodes) {
+			end = offsets[i+1]
+		}
+
+		if offsets[i]+int64(mappedNodeSize+8*len(links[i])+len(key)+len(data)) != end {
+			return errors.New("codecs encoded a key or value differently twice")
+		}
+
+		writeNode(links[i], key, data)
+	}
+
+	return bw.Flush()
+}
+
+// Mapped is a read-only skiplist written by WriteMapped, and mapped into memory by Open. Select
+// and SelectRange search the mapping in place, without loading the list into the heap, so many
+// processes can share the same file through the page cache. Where mmap isn't available, Open
+// reads the file into memory instead.
+//
+// Keys are decoded with the key codec to be compared while searching, except for the keys of
+// lists created with NewMappedBytes, and string keys of lists created with NewMappedOrdered,
+// which are compared without decoding them.
+type Mapped[K, V any] struct {
+	compare     func(k1, k2 K) (int, error)
+	compareName string
+	keyCodec    Codec[K]
+	valueCodec  Codec[V]
+
+	// rawCompare compares encoded keys, if their encoding sorts the same as the keys
+	rawCompare func(a, b []byte) int
+
+	dynamic bool
+
+	// The mapping, and what the header says about it. version is incremented each time a file is
+	// opened, so iterators over a closed file can tell.
+	data    []byte
+	mapped  bool
+	level   int
+	count   int
+	head    int64
+	version uint64
+
+	mutex sync.RWMutex
+}
+
+// NewMapped creates a mapped list of interface{} keys and values, ordered by compare, to open
+// files written by lists created with New.
+func NewMapped[C Comparer](compare C) *Mapped[interface{}, interface{}] {
+	m := newMapped[interface{}, interface{}](toCompare(compare))
+	m.compareName = funcName(compare)
+	return m
+}
+
+// NewMappedOrdered creates a mapped list to open files written by lists created with NewOrdered.
+func NewMappedOrdered[K cmp.Ordered, V any]() *Mapped[K, V] {
+	m := newMapped[K, V](func(k1, k2 K) (int, error) {
+		return cmp.Compare(k1, k2), nil
+	})
+	m.compareName = "cmp.Compare"
+
+	// The builtin codec encodes strings as their bytes, which sort the same as the strings
+	if reflect.TypeFor[K]() == reflect.TypeFor[string]() {
+		m.rawCompare = bytes.Compare
+	}
+	return m
+}
+
+// NewMappedBytes creates a mapped list to open files written by lists created with NewBytes.
+// Keys are compared without decoding them.
+func NewMappedBytes[V any]() *Mapped[[]byte, V] {
+	m := newMapped[[]byte, V](func(k1, k2 []byte) (int, error) {
+		return bytes.Compare(k1, k2), nil
+	})
+	m.compareName = "bytes.Compare"
+	m.rawCompare = bytes.Compare
+	return m
+}
+
+// NewMappedFunc creates a mapped list to open files written by lists created with NewFunc, with
+// the same compare function.
+func NewMappedFunc[K, V any](compare func(k1, k2 K) int) *Mapped[K, V] {
+	m := newMapped[K, V](func(k1, k2 K) (int, error) {
+		return compare(k1, k2), nil
+	})
+	m.compareName = funcName(compare)
+	return m
+}
+
+func newMapped[K, V any](compare func(k1, k2 K) (int, error)) *Mapped[K, V] {
+	return &Mapped[K, V]{
+		compare:    compare,
+		keyCodec:   BuiltinCodec[K](),
+		valueCodec: BuiltinCodec[V](),
+		dynamic:    reflect.TypeFor[K]().Kind() == reflect.Interface,
+	}
+}
+
+// SetCodecs sets the codecs the keys and values were written with. It can't be called while a
+// file is open.
+func (this *Mapped[K, V]) SetCodecs(keys Codec[K], values Codec[V]) (err error) {
+	if keys == nil || values == nil {
+		return errors.New("skiplist/Mapped.SetCodecs: trying to set codec to nil")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.data != nil {
+		return errors.New("skiplist/Mapped.SetCodecs: file is open")
+	}
+
+	this.keyCodec, this.valueCodec = keys, values
+	this.rawCompare = nil
+	return nil
+}
+
+// SetCompareName sets the comparator name Open expects in the file, for files written by a list
+// with the same name set by Skiplist.SetCompareName. It can't be called while a file is open.
+func (this *Mapped[K, V]) SetCompareName(name string) (err error) {
+	if name == "" {
+		return errors.New("skiplist/Mapped.SetCompareName: name is empty")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.data != nil {
+		return errors.New("skiplist/Mapped.SetCompareName: file is open")
+	}
+
+	this.compareName = name
+	return nil
+}
+
+// Open maps the file at path, written by WriteMapped, into memory. The list it was written from
+// must have had the same comparator and key and value types.
+func (this *Mapped[K, V]) Open(path string) (err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.data != nil {
+		return errors.New("skiplist/Mapped.Open: file is already open")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("skiplist/Mapped.Open: " + err.Error())
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errors.New("skiplist/Mapped.Open: " + err.Error())
+	}
+
+	if info.Size() < mappedHeaderSize || info.Size() != int64(int(info.Size())) {
+		return errors.New("skiplist/Mapped.Open: file is not a mapped skiplist")
+	}
+
+	data, mapped, err := mmap(file, int(info.Size()))
+	if err != nil {
+		return errors.New("skiplist/Mapped.Open: " + err.Error())
+	}
+
+	this.data, this.mapped = data, mapped
+	if err = this.readHeader(); err != nil {
+		this.unmap()
+		return errors.New("skiplist/Mapped.Open: " + err.Error())
+	}
+
+	this.version++
+	return nil
+}
+
+// readHeader checks the header of the mapping, and reads the level, count and head offset. The
+// caller must hold the write lock.
+func (this *Mapped[K, V]) readHeader() error {
+	data := this.data
+	if string(data[:len(mappedMagic)]) != mappedMagic {
+		return errors.New("file is not a mapped skiplist")
+	}
+
+	if version := binary.LittleEndian.Uint32(data[4:]); version != mappedVersion {
+		return errors.New("unsupported version " + strconv.FormatUint(uint64(version), 10))
+	}
+
+	level := binary.LittleEndian.Uint32(data[8:])
+	count := binary.LittleEndian.Uint64(data[12:])
+
+	// The comparator and type names are checked once the checksum shows they aren't corrupt
+	p := mappedHeaderSize
+	for i := 0; i < 3; i++ {
+		if len(data)-p < 4 {
+			return errors.New("header is corrupt")
+		}
+
+		n := int(binary.LittleEndian.Uint32(data[p:]))
+		if n > maxNameLength || len(data)-p-4 < n {
+			return errors.New("header is corrupt")
+		}
+		p += 4 + n
+	}
+
+	if len(data)-p < 4 || crc32.Checksum(data[:p], crcTable) != binary.LittleEndian.Uint32(data[p:]) {
+		return errors.New("header is corrupt")
+	}
+
+	p = mappedHeaderSize
+	for _, expected := range []string{this.compareName, reflect.TypeFor[K]().String(), reflect.TypeFor[V]().String()} {
+		n := int(binary.LittleEndian.Uint32(data[p:]))
+		if name := string(data[p+4 : p+4+n]); name != expected {
+			return errors.New("file has " + name + ", the list has " + expected)
+		}
+		p += 4 + n
+	}
+
+	if level < 1 || level > maxLevelLimit || count > uint64(len(data)) {
+		return errors.New("header is corrupt")
+	}
+
+	this.level, this.count, this.head = int(level), int(count), int64(p+4)
+	if _, err := this.node(this.head); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close unmaps the file. Slices returned by the iterators' KeyBytes and ValueBytes point into the
+// mapping, so they must not be used after Close.
+func (this *Mapped[K, V]) Close() (err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.data == nil {
+		return nil
+	}
+
+	if err = this.unmap(); err != nil {
+		return errors.New("skiplist/Mapped.Close: " + err.Error())
+	}
+	return nil
+}
+
+// unmap releases the mapping. The caller must hold the write lock.
+func (this *Mapped[K, V]) unmap() (err error) {
+	if this.mapped {
+		err = munmap(this.data)
+	}
+
+	this.data, this.mapped = nil, false
+	return err
+}
+
+// Count returns the number of nodes in the open file.
+func (this *Mapped[K, V]) Count() int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	return this.count
+}
+
+// mappedNode is a node in the mapping.
+type mappedNode struct {
+	links []byte
+	key   []byte
+	value []byte
+}
+
+// next returns the offset of the next node at level l, or 0 if there's none.
+func (this mappedNode) next(l int) int64 {
+	if 8*l >= len(this.links) {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(this.links[8*l:]))
+}
+
+// node returns the node at offset off, checking that it's within the mapping. The caller must
+// hold the lock.
+func (this *Mapped[K, V]) node(off int64) (n mappedNode, err error) {
+	data := this.data
+	if off < 0 || off > int64(len(data))-mappedNodeSize {
+		return n, errors.New("corrupt node at offset " + strconv.FormatInt(off, 10))
+	}
+
+	level := int64(data[off])
+	klen := int64(binary.LittleEndian.Uint32(data[off+1:]))
+	vlen := int64(binary.LittleEndian.Uint32(data[off+5:]))
+
+	p := off + mappedNodeSize
+	if level < 1 || level > int64(this.level) || int64(len(data))-p < 8*level+klen+vlen {
+		return n, errors.New("corrupt node at offset " + strconv.FormatInt(off, 10))
+	}
+
+	n.links = data[p : p+8*level]
+	n.key = data[p+8*level : p+8*level+klen]
+	n.value = data[p+8*level+klen : p+8*level+klen+vlen]
+	return n, nil
+}
+
+// follow returns the node after the node at off at level l, and its offset, which is 0 if there's
+// none. The caller must hold the lock.
+func (this *Mapped[K, V]) follow(off int64, n mappedNode, l int) (int64, mappedNode, error) {
+	next := n.next(l)
+	if next == 0 {
+		return 0, mappedNode{}, nil
+	}
+
+	// Offsets only point forward, so a corrupt file can't make a search loop
+	if next <= off {
+		return 0, mappedNode{}, errors.New("corrupt node at offset " + strconv.FormatInt(off, 10))
+	}
+
+	n, err := this.node(next)
+	return next, n, err
+}
+
+// compareKey compares the encoded key data with key, whose encoding is raw if the keys are
+// compared without decoding them.
+func (this *Mapped[K, V]) compareKey(data []byte, key K, raw []byte) (int, error) {
+	if this.rawCompare != nil {
+		return this.rawCompare(data, raw), nil
+	}
+
+	k, err := this.keyCodec.Decode(data)
+	if err != nil {
+		return 0, errors.New("error decoding key; " + err.Error())
+	}
+
+	return this.compare(k, key)
+}
+
+// encodeBound returns the encoding of the key of b, if the keys are compared without decoding them.
+func (this *Mapped[K, V]) encodeBound(b Bound[K]) ([]byte, error) {
+	if this.rawCompare == nil || b.kind == unbounded {
+		return nil, nil
+	}
+
+	return this.keyCodec.Append(nil, b.key)
+}
+
+// afterLo returns true if the encoded key data is within the lower bound lo.
+func (this *Mapped[K, V]) afterLo(lo Bound[K], raw, data []byte) (bool, error) {
+	if lo.kind == unbounded {
+		return true, nil
+	}
+
+	c, err := this.compareKey(data, lo.key, raw)
+	return c > 0 || (c == 0 && lo.kind == inclusive), err
+}
+
+// beforeHi returns true if the encoded key data is within the upper bound hi.
+func (this *Mapped[K, V]) beforeHi(hi Bound[K], raw, data []byte) (bool, error) {
+	if hi.kind == unbounded {
+		return true, nil
+	}
+
+	c, err := this.compareKey(data, hi.key, raw)
+	return c < 0 || (c == 0 && hi.kind == inclusive), err
+}
+
+// seekLo returns the offset of the first node within lo, or 0 if there's none. The caller must
+// hold the lock.
+func (this *Mapped[K, V]) seekLo(lo Bound[K], raw []byte) (int64, error) {
+	p := this.head
+	pn, err := this.node(p)
+	if err != nil {
+		return 0, err
+	}
+
+	for l := this.level - 1; l >= 0; l-- {
+		for {
+			off, n, err := this.follow(p, pn, l)
+			if err != nil {
+				return 0, err
+			} else if off == 0 {
+				break
+			}
+
+			if ok, err := this.afterLo(lo, raw, n.key); err != nil {
+				return 0, err
+			} else if ok {
+				break
+			}
+			p, pn = off, n
+		}
+	}
+
+	return pn.next(0), nil
+}
+
+// checkBounds returns an error if the keys of lo or hi are nil, or no file is open. The caller
+// must hold the lock.
+func (this *Mapped[K, V]) checkBounds(name string, lo, hi Bound[K]) error {
+	if this.dynamic && ((lo.kind != unbounded && any(lo.key) == nil) || (hi.kind != unbounded && any(hi.key) == nil)) {
+		return errors.New("skiplist/Mapped." + name + ": key is nil")
+	}
+
+	if this.compare == nil {
+		return errors.New("skiplist/Mapped." + name + ": comparator is not set (== nil)")
+	}
+
+	if this.data == nil {
+		return errors.New("skiplist/Mapped." + name + ": file is not open")
+	}
+
+	return nil
+}
+
+// Get returns the value of the first node with key, and false if there's no such node.
+func (this *Mapped[K, V]) Get(key K) (value V, ok bool, err error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	lo := Inclusive(key)
+	if err = this.checkBounds("Get", lo, lo); err != nil {
+		return
+	}
+
+	raw, err := this.encodeBound(lo)
+	if err != nil {
+		return value, false, errors.New("skiplist/Mapped.Get: error encoding key; " + err.Error())
+	}
+
+	off, err := this.seekLo(lo, raw)
+	if err != nil || off == 0 {
+		return value, false, this.wrap("Get", err)
+	}
+
+	n, err := this.node(off)
+	if err != nil {
+		return value, false, this.wrap("Get", err)
+	}
+
+	if ok, err = this.beforeHi(lo, raw, n.key); err != nil || !ok {
+		return value, false, this.wrap("Get", err)
+	}
+
+	if value, err = this.valueCodec.Decode(n.value); err != nil {
+		return value, false, errors.New("skiplist/Mapped.Get: error decoding value; " + err.Error())
+	}
+
+	return value, true, nil
+}
+
+// wrap adds the method name to err, if it's not nil.
+func (this *Mapped[K, V]) wrap(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return errors.New("skiplist/Mapped." + name + ": " + err.Error())
+}
+
+// Select returns an iterator over the nodes with key.
+func (this *Mapped[K, V]) Select(key K) (*MappedIterator[K, V], error) {
+	return this.selectBounds("Select", Inclusive(key), Inclusive(key))
+}
+
+// SelectRange returns an iterator over the nodes between key1 and key2, inclusive.
+func (this *Mapped[K, V]) SelectRange(key1, key2 K) (*MappedIterator[K, V], error) {
+	return this.selectBounds("SelectRange", Inclusive(key1), Inclusive(key2))
+}
+
+// SelectBounds returns an iterator over the nodes between lo and hi.
+func (this *Mapped[K, V]) SelectBounds(lo, hi Bound[K]) (*MappedIterator[K, V], error) {
+	return this.selectBounds("SelectBounds", lo, hi)
+}
+
+func (this *Mapped[K, V]) selectBounds(name string, lo, hi Bound[K]) (iter *MappedIterator[K, V], err error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if err = this.checkBounds(name, lo, hi); err != nil {
+		return nil, err
+	}
+
+	iter = &MappedIterator[K, V]{list: this, version: this.version, hi: hi}
+	if iter.rawHi, err = this.encodeBound(hi); err != nil {
+		return nil, errors.New("skiplist/Mapped." + name + ": error encoding key; " + err.Error())
+	}
+
+	raw, err := this.encodeBound(lo)
+	if err != nil {
+		return nil, errors.New("skiplist/Mapped." + name + ": error encoding key; " + err.Error())
+	}
+
+	if iter.next, err = this.seekLo(lo, raw); err != nil {
+		return nil, this.wrap(name, err)
+	}
+
+	return iter, nil
+}
+
+// MappedIterator walks the nodes of a Mapped list returned by Select, SelectRange and SelectBounds,
+// in order. It reads the nodes in place, so KeyBytes and ValueBytes return the encoded keys and
+// values without copying them, and Key and Value decode them on demand.
+type MappedIterator[K, V any] struct {
+	list    *Mapped[K, V]
+	version uint64
+
+	hi    Bound[K]
+	rawHi []byte
+
+	// Offset of the node Next moves to, or 0 at the end
+	next int64
+
+	key   []byte
+	value []byte
+	err   error
+}
+
+// Next moves the iterator to the next node, and returns false if there are no more nodes or an
+// error occurred.
+func (this *MappedIterator[K, V]) Next() bool {
+	this.key, this.value = nil, nil
+	if this.err != nil || this.next == 0 {
+		return false
+	}
+
+	m := this.list
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if !this.open() {
+		return false
+	}
+
+	off := this.next
+	n, err := m.node(off)
+	if err != nil {
+		this.err = m.wrap("MappedIterator", err)
+		return false
+	}
+
+	if ok, err := m.beforeHi(this.hi, this.rawHi, n.key); err != nil {
+		this.err = m.wrap("MappedIterator", err)
+		return false
+	} else if !ok {
+		this.next = 0
+		return false
+	}
+
+	if this.next = n.next(0); this.next != 0 && this.next <= off {
+		this.err = errors.New("skiplist/MappedIterator: corrupt node at offset " + strconv.FormatInt(off, 10))
+		return false
+	}
+
+	this.key, this.value = n.key, n.value
+	return true
+}
+
+// open checks that the file hasn't been closed, or closed and opened again, since the iterator
+// was created, and sets the error if it has. The caller must hold the read lock.
+func (this *MappedIterator[K, V]) open() bool {
+	if m := this.list; m.data == nil || m.version != this.version {
+		this.err = errors.New("skiplist/MappedIterator: file is closed")
+		return false
+	}
+
+	return true
+}
+
+// Key decodes the key at the current position. If it can't be decoded, or the list was closed
+// since the iterator moved there, the zero K is returned, and Err returns the error.
+func (this *MappedIterator[K, V]) Key() (key K) {
+	if this.key == nil {
+		return
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	if !this.open() {
+		return
+	}
+
+	key, err := this.list.keyCodec.Decode(this.key)
+	if err != nil && this.err == nil {
+		this.err = errors.New("skiplist/MappedIterator: error decoding key; " + err.Error())
+	}
+	return key
+}
+
+// Value decodes the value at the current position. If it can't be decoded, or the list was
+// closed since the iterator moved there, the zero V is returned, and Err returns the error.
+func (this *MappedIterator[K, V]) Value() (value V) {
+	if this.value == nil {
+		return
+	}
+
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	if !this.open() {
+		return
+	}
+
+	value, err := this.list.valueCodec.Decode(this.value)
+	if err != nil && this.err == nil {
+		this.err = errors.New("skiplist/MappedIterator: error decoding value; " + err.Error())
+	}
+	return value
+}
+
+// KeyBytes returns the encoded key at the current position, which points into the mapping, and
+// must not be modified or used after the list is closed.
+func (this *MappedIterator[K, V]) KeyBytes() []byte {
+	return this.key
+}
+
+// ValueBytes returns the encoded value at the current position, which points into the mapping,
+// and must not be modified or used after the list is closed.
+func (this *MappedIterator[K, V]) ValueBytes() []byte {
+	return this.value
+}
+
+// Err returns the error, if any, that stopped the iterator, or occurred decoding a key or value.
+func (this *MappedIterator[K, V]) Err() error {
+	return this.err
+}
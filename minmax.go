@@ -0,0 +1,138 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+// Min returns the first node in the list in O(1), or false if the list is empty.
+//
+// Duplicate keys are inserted after the equal keys already in the list, so used as a priority
+// queue, Min, PopMin and PopN return equal priorities first in, first out. Max and PopMax return
+// the last node, which is the most recently inserted one among equal keys, so a queue that pops
+// the largest priorities first with ties in order can negate its priorities and use PopMin.
+func (this *Skiplist[K, V]) Min() (key K, value V, ok bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if p := this.skip(this.headNode.next[0], current, false); p != nil {
+		return p.key, p.value, true
+	}
+
+	return
+}
+
+// Max returns the last node in the list in O(log n), or false if the list is empty.
+func (this *Skiplist[K, V]) Max() (key K, value V, ok bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if p := this.skip(this.last(), current, true); p != this.headNode {
+		return p.key, p.value, true
+	}
+
+	return
+}
+
+// PopMin deletes and returns the first node in the list, or false if the list is empty. Among
+// equal keys, it returns the least recently inserted node first.
+func (this *Skiplist[K, V]) PopMin() (key K, value V, ok bool, err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.count == 0 {
+		return
+	}
+
+	if err = this.logDeleteAt("PopMin", 0, 1); err != nil {
+		return
+	}
+
+	if p := this.popMin(); p != nil {
+		return p.key, p.value, true, nil
+	}
+
+	return
+}
+
+// PopMax deletes and returns the last node in the list, or false if the list is empty. Among
+// equal keys, it returns the most recently inserted node first.
+func (this *Skiplist[K, V]) PopMax() (key K, value V, ok bool, err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.count == 0 {
+		return
+	}
+
+	if err = this.logDeleteAt("PopMax", this.count-1, 1); err != nil {
+		return
+	}
+
+	p := this.nodeAt(this.count-1, this.deleteFingers)
+	key, value = p.key, p.value
+	this.remove(this.deleteFingers, p)
+
+	return key, value, true, nil
+}
+
+// PopN deletes the first n nodes in the list, or all of them if there are fewer than n, and
+// returns them in an iterator, in the order PopMin would return them.
+func (this *Skiplist[K, V]) PopN(n int) (iter *Iterator[K, V], err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	iter = newIterator[K, V]()
+	iter.compare = this.compare
+
+	if n > 0 && this.count > 0 {
+		if err = this.logDeleteAt("PopN", 0, min(n, this.count)); err != nil {
+			return
+		}
+	}
+
+	for ; n > 0; n-- {
+		p := this.popMin()
+		if p == nil {
+			break
+		}
+
+		iter.buf = append(iter.buf, p)
+		iter.count++
+	}
+
+	return iter, nil
+}
+
+// popMin deletes the first node in the list and returns it, detached, or nil if the list is
+// empty. The caller must hold the write lock.
+func (this *Skiplist[K, V]) popMin() *node[K, V] {
+	p := this.headNode.next[0]
+	if p == nil || this.count == 0 {
+		return nil
+	}
+
+	if p.dead == 0 {
+		// headNode is the rightmost node before the first node at every level
+		this.deleteFingers.fit(len(this.headNode.next))
+		for i := 0; i < this.level; i++ {
+			this.deleteFingers.nodes[i] = this.headNode
+		}
+	} else {
+		p = this.nodeAt(0, this.deleteFingers)
+	}
+
+	n := this.detach(p)
+	this.remove(this.deleteFingers, p)
+
+	return n
+}
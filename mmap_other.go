@@ -0,0 +1,35 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package skiplist
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the first size bytes of file into memory, since it can't be mapped on this platform.
+func mmap(file *os.File, size int) (data []byte, mapped bool, err error) {
+	data = make([]byte, size)
+	if _, err = io.ReadFull(file, data); err != nil {
+		return nil, false, err
+	}
+	return data, false, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
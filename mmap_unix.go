@@ -0,0 +1,32 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package skiplist
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the first size bytes of file into memory, read-only and shared with other processes.
+func mmap(file *os.File, size int) (data []byte, mapped bool, err error) {
+	data, err = syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	return data, err == nil, err
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
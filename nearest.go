@@ -0,0 +1,85 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import "errors"
+
+// Floor returns the last node with a key at or before key, e.g. the last node with a key <= key
+// for an ascending list. ok is false if there's no such node.
+func (this *Skiplist[K, V]) Floor(key K) (k K, v V, ok bool, err error) {
+	return this.nearest("Floor", key, false, true)
+}
+
+// Ceiling returns the first node with a key at or after key. ok is false if there's no such node.
+func (this *Skiplist[K, V]) Ceiling(key K) (k K, v V, ok bool, err error) {
+	return this.nearest("Ceiling", key, true, true)
+}
+
+// Lower returns the last node with a key strictly before key. ok is false if there's no such
+// node.
+func (this *Skiplist[K, V]) Lower(key K) (k K, v V, ok bool, err error) {
+	return this.nearest("Lower", key, false, false)
+}
+
+// Higher returns the first node with a key strictly after key. ok is false if there's no such
+// node.
+func (this *Skiplist[K, V]) Higher(key K) (k K, v V, ok bool, err error) {
+	return this.nearest("Higher", key, true, false)
+}
+
+// nearest returns the node closest to key in the given direction, which is key itself if
+// inclusive is true and key is in the list. name is the calling method, used in errors.
+func (this *Skiplist[K, V]) nearest(name string, key K, after, inclusive bool) (k K, v V, ok bool, err error) {
+	if err = this.checkKey(name, key); err != nil {
+		return
+	}
+
+	f := this.getFingers()
+	defer this.putFingers(f)
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	// p is the rightmost node before key
+	p, err := this.before(key, f)
+	if err != nil {
+		return
+	}
+
+	// Only walk past the nodes with key if they are excluded going forward, or included
+	// going backward
+	if after != inclusive {
+		for n := p.next[0]; n != nil; n = n.next[0] {
+			if c, err := this.compare(n.key, key); err != nil {
+				return k, v, false, errors.New("skiplist/" + name + ": error comparing keys; " + err.Error())
+			} else if c > 0 {
+				break
+			}
+			p = n
+		}
+	}
+
+	if after {
+		p = this.skip(p.next[0], current, false)
+	} else {
+		p = this.skip(p, current, true)
+	}
+
+	if p == nil || p == this.headNode {
+		return
+	}
+
+	return p.key, p.value, true, nil
+}
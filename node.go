@@ -14,40 +14,81 @@
 
 package skiplist
 
-type node struct {
-	next  []*node
-	key   interface{}
-	value interface{}
+type node[K, V any] struct {
+	next []*node[K, V]
+
+	// prev points back to the previous node at level 0, which is headNode for the first node
+	prev *node[K, V]
+
+	// span[i] is the number of live nodes after this node, up to and including next[i], if
+	// next[i] is not nil. Without snapshots, that's the number of level 0 steps to next[i].
+	span []int
+
+	key   K
+	value V
+
+	// seq is the list's sequence number when the node was inserted, and dead the sequence number
+	// when it was deleted, or 0 if it's live. Deleted nodes are only kept as tombstones while a
+	// snapshot can still see them.
+	seq  uint64
+	dead uint64
 }
 
 // Create a new node with l levels of pointers
-func newNode(l int) *node {
-	return &node{
-		next: make([]*node, l),
+func newNode[K, V any](l int) *node[K, V] {
+	return &node[K, V]{
+		next: make([]*node[K, V], l),
+		span: make([]int, l),
+	}
+}
+
+// detachedNode returns a node with key and value that isn't in any list, for results that are
+// copies of nodes. It has one level, so Next and NextAtLevel(0) return nil like Prev, instead of
+// panicking.
+func detachedNode[K, V any](key K, value V) *node[K, V] {
+	return &node[K, V]{
+		next:  make([]*node[K, V], 1),
+		key:   key,
+		value: value,
 	}
 }
 
-func (this *node) SetKey(key interface{}) {
+// visible returns true if the node is in the list as of sequence number seq.
+func (this *node[K, V]) visible(seq uint64) bool {
+	return this.seq <= seq && (this.dead == 0 || this.dead > seq)
+}
+
+// linked returns true if the node hasn't been unlinked from its list. An unlinked node keeps its
+// prev pointer, but prev doesn't point to it anymore.
+func (this *node[K, V]) linked() bool {
+	return this.prev != nil && this.prev.next[0] == this
+}
+
+func (this *node[K, V]) SetKey(key K) {
 	this.key = key
 }
 
-func (this *node) GetKey() (key interface{}) {
+func (this *node[K, V]) GetKey() (key K) {
 	return this.key
 }
 
-func (this *node) SetValue(value interface{}) {
+func (this *node[K, V]) SetValue(value V) {
 	this.value = value
 }
 
-func (this *node) GetValue() (key interface{}) {
+func (this *node[K, V]) GetValue() (value V) {
 	return this.value
 }
 
-func (this *node) Next() *node {
+func (this *node[K, V]) Next() *node[K, V] {
 	return this.next[0]
 }
 
-func (this *node) NextAtLevel(l int) *node {
+func (this *node[K, V]) Prev() *node[K, V] {
+	return this.prev
+}
+
+func (this *node[K, V]) NextAtLevel(l int) *node[K, V] {
 	if l >= 0 && l < len(this.next) {
 		return this.next[l]
 	}
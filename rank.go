@@ -0,0 +1,146 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import "errors"
+
+// Rank returns the 0-based position in the list of the first node with key, or -1 if there's no
+// such node.
+func (this *Skiplist[K, V]) Rank(key K) (rank int, err error) {
+	if err = this.checkKey("Rank", key); err != nil {
+		return -1, err
+	}
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	// Walk down the levels, adding up the spans of the nodes before key
+	p := this.headNode
+	for l := this.level - 1; l >= 0; l-- {
+		for n := p.next[l]; n != nil; n = n.next[l] {
+			if c, err := this.compare(n.key, key); err != nil {
+				return -1, errors.New("skiplist/Rank: error comparing keys; " + err.Error())
+			} else if c >= 0 {
+				break
+			}
+			rank += p.span[l]
+			p = n
+		}
+	}
+
+	// Tombstones don't count in the spans, so skipping them doesn't change the rank
+	if p = this.skip(p.next[0], current, false); p != nil {
+		if c, err := this.compare(p.key, key); err != nil {
+			return -1, errors.New("skiplist/Rank: error comparing keys; " + err.Error())
+		} else if c == 0 {
+			return rank, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// At returns the key and value of the node at the 0-based position i, and false if i is out of
+// range.
+func (this *Skiplist[K, V]) At(i int) (key K, value V, ok bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if i < 0 || i >= this.count {
+		return
+	}
+
+	p := this.nodeAt(i, nil)
+	return p.key, p.value, true
+}
+
+// RangeByIndex returns the nodes at positions i through j-1. i and j are clamped to the size of
+// the list. The nodes are copied into the iterator, so it doesn't change if the list does.
+func (this *Skiplist[K, V]) RangeByIndex(i, j int) *Iterator[K, V] {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	iter := newIterator[K, V]()
+	iter.compare = this.compare
+
+	if i < 0 {
+		i = 0
+	}
+
+	if j > this.count {
+		j = this.count
+	}
+
+	if i >= j {
+		return iter
+	}
+
+	for p := this.nodeAt(i, nil); iter.count < j-i; p = p.next[0] {
+		if p.dead != 0 {
+			continue
+		}
+
+		iter.buf = append(iter.buf, this.detach(p))
+		iter.count++
+	}
+
+	return iter
+}
+
+// DeleteAt deletes the node at the 0-based position i, and returns its key and value. It returns
+// false if i is out of range.
+func (this *Skiplist[K, V]) DeleteAt(i int) (key K, value V, ok bool, err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if i < 0 || i >= this.count {
+		return
+	}
+
+	if err = this.logDeleteAt("DeleteAt", i, 1); err != nil {
+		return
+	}
+
+	p := this.nodeAt(i, this.deleteFingers)
+	key, value = p.key, p.value
+	this.remove(this.deleteFingers, p)
+
+	return key, value, true, nil
+}
+
+// nodeAt returns the node at the 0-based position i, which must be in range. If f is not nil, it's
+// positioned on the rightmost nodes before the node at each level. The caller must hold the lock.
+func (this *Skiplist[K, V]) nodeAt(i int, f *fingers[K, V]) *node[K, V] {
+	// r is the 1-based position of p, with headNode at 0
+	// Tombstones have the same position as the live node before them, so they are passed on the
+	// way, and the node after p is the live node at position i
+	p, r := this.headNode, 0
+	if f != nil {
+		f.fit(len(this.headNode.next))
+	}
+
+	for l := this.level - 1; l >= 0; l-- {
+		for p.next[l] != nil && r+p.span[l] <= i {
+			r += p.span[l]
+			p = p.next[l]
+		}
+
+		if f != nil {
+			f.nodes[l] = p
+		}
+	}
+
+	return p.next[0]
+}
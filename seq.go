@@ -0,0 +1,166 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import "iter"
+
+// All returns an iterator over all the key/value pairs in the list, for use with range:
+//
+//	for k, v := range list.All() { ... }
+//
+// The list's read lock is taken for each step and released while the loop body runs, so the body
+// can modify the list. As with lazy iterators, nodes inserted or deleted during the loop may or may
+// not be seen.
+func (this *Skiplist[K, V]) All() iter.Seq2[K, V] {
+	return this.RangeBounds(Unbounded[K](), Unbounded[K]())
+}
+
+// Backward returns an iterator over all the key/value pairs in the list in reverse order. It works
+// like All otherwise.
+func (this *Skiplist[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		f := this.getFingers()
+		defer this.putFingers(f)
+
+		this.mutex.RLock()
+		p := this.last()
+
+		for p != this.headNode {
+			if p.dead != 0 {
+				p = p.prev
+				continue
+			}
+
+			key, value, version := p.key, p.value, this.version
+			this.mutex.RUnlock()
+
+			if !yield(key, value) {
+				return
+			}
+
+			this.mutex.RLock()
+
+			var err error
+			if p, err = this.resume(p, key, version, f, true); err != nil {
+				break
+			}
+		}
+
+		this.mutex.RUnlock()
+	}
+}
+
+// Range returns an iterator over the key/value pairs with key1 <= key <= key2. It works like All
+// otherwise. The loop stops early if the comparator returns an error, use SelectRange if the error
+// has to be reported.
+func (this *Skiplist[K, V]) Range(key1, key2 K) iter.Seq2[K, V] {
+	return this.RangeBounds(Inclusive(key1), Inclusive(key2))
+}
+
+// RangeBounds returns an iterator over the key/value pairs between lo and hi. It works like
+// Range otherwise.
+func (this *Skiplist[K, V]) RangeBounds(lo, hi Bound[K]) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		if this.checkBounds("RangeBounds", lo, hi) != nil {
+			return
+		}
+
+		f := this.getFingers()
+		defer this.putFingers(f)
+
+		this.mutex.RLock()
+
+		var p *node[K, V]
+		if this.seekLo(lo, f) == nil {
+			p = f.nodes[0].next[0]
+		}
+
+		for p != nil {
+			if ok, err := this.beforeHi(hi, p.key); err != nil || !ok {
+				break
+			}
+
+			if p.dead != 0 {
+				p = p.next[0]
+				continue
+			}
+
+			key, value, version := p.key, p.value, this.version
+			this.mutex.RUnlock()
+
+			if !yield(key, value) {
+				return
+			}
+
+			this.mutex.RLock()
+
+			var err error
+			if p, err = this.resume(p, key, version, f, false); err != nil {
+				break
+			}
+		}
+
+		this.mutex.RUnlock()
+	}
+}
+
+// resume returns the node after p, or before p if backward is true, once the read lock has been
+// taken again after yielding p. If the list changed in between, p may have been unlinked, so the
+// next node is found by searching for key again. If p is gone, the nodes with the same key as p
+// that hadn't been reached yet are skipped along with it. The caller must hold the read lock.
+func (this *Skiplist[K, V]) resume(p *node[K, V], key K, version uint64, f *fingers[K, V], backward bool) (*node[K, V], error) {
+	if version == this.version {
+		if backward {
+			return p.prev, nil
+		}
+		return p.next[0], nil
+	}
+
+	if backward {
+		n, err := this.atOrBefore(key)
+		if err != nil {
+			return nil, err
+		}
+
+		for ; n != this.headNode; n = n.prev {
+			if n == p {
+				return p.prev, nil
+			} else if c, err := this.compare(n.key, key); err != nil {
+				return nil, err
+			} else if c != 0 {
+				return n, nil
+			}
+		}
+
+		return n, nil
+	}
+
+	n, err := this.before(key, f)
+	if err != nil {
+		return nil, err
+	}
+
+	for n = n.next[0]; n != nil; n = n.next[0] {
+		if n == p {
+			return p.next[0], nil
+		} else if c, err := this.compare(n.key, key); err != nil {
+			return nil, err
+		} else if c != 0 {
+			return n, nil
+		}
+	}
+
+	return nil, nil
+}
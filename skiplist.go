@@ -15,12 +15,21 @@
 package skiplist
 
 import (
+	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"reflect"
+	"runtime"
 	"sync"
+	"time"
+)
+
+var (
+	// ErrDuplicateKey is returned by Insert when a list in unique mode already has the key.
+	ErrDuplicateKey = errors.New("skiplist/Insert: duplicate key")
 )
 
 var (
@@ -28,7 +37,13 @@ var (
 	DefaultProbability float32 = 0.25
 )
 
-type Skiplist struct {
+// current is the sequence number used to read the list as it is now, rather than as of a snapshot.
+const current uint64 = math.MaxUint64
+
+// Skiplist is a sorted list of key/value pairs, ordered by the keys using the list's comparator.
+// Lists created with New use interface{} keys and values; NewOrdered and NewFunc create typed
+// lists that do not box keys or go through reflection when comparing them.
+type Skiplist[K, V any] struct {
 	// Determining MaxLevel
 	// Reference: http://drum.lib.umd.edu/bitstream/1903/544/2/CS-TR-2286.1.pdf - section 2
 	//
@@ -54,61 +69,210 @@ type Skiplist struct {
 	// headNode is the first node in the skiplist. The next pointers in headNode always points forward
 	// to the next node at the appropriate height. Initially all the next pointers will point to tailNode.
 	// All of the prev pointers will remain nil.
-	headNode *node
+	headNode *node[K, V]
 
 	// Using Search Fingers
 	// Reference: http://drum.lib.umd.edu/bitstream/1903/544/2/CS-TR-2286.1.pdf - section 3.1
-	// We keep two sets of fingers as search and insert localities are likely different, especially if
-	// the insert keys are close to each other
-	insertFingers []*node
+	// We keep two sets of fingers as insert and delete localities are likely different, especially if
+	// the insert keys are close to each other. These are only used while holding the write lock.
+	insertFingers *fingers[K, V]
 
-	// fingers for selecting nodes
-	selectFingers []*node
+	// fingers for deleting nodes
+	deleteFingers *fingers[K, V]
+
+	// Readers search concurrently under the read lock, so they can't share fingers. Readers that
+	// don't have their own fingers (see Finger) get them from this pool.
+	fingerPool sync.Pool
+
+	// version is incremented each time nodes are inserted or deleted. Search fingers are only
+	// reused if they were positioned at the current version, or kept up to date by the change.
+	version uint64
 
 	// Total number of nodes inserted
 	count int
 
-	// Comparison function for the node keys.
-	// For ascending order - if k1 < k2 return true; else return false
-	// For descending order - if k1 > k2 return true; else return false
-	compare Comparator
+	// seq is incremented each time a node is inserted or becomes a tombstone. Nodes record the
+	// sequence numbers of their insert and delete, so snapshots can tell which nodes they see.
+	seq uint64
+
+	// Sequence numbers of the open snapshots, in ascending order
+	snapshots []uint64
+
+	// Deleted nodes that are kept in the list because a snapshot can see them
+	tombstones []*node[K, V]
+
+	// Three-way comparison function for the node keys. It returns a negative number if k1 sorts
+	// before k2, a positive number if k1 sorts after k2, and zero if the keys are equal.
+	// For ascending order - if k1 < k2 return -1
+	// For descending order - if k1 > k2 return -1
+	compare func(k1, k2 K) (int, error)
+
+	// Name of the comparator, recorded by MarshalBinary so that UnmarshalBinary can check that
+	// the data is sorted the same way
+	compareName string
+
+	// Codecs used by MarshalBinary and UnmarshalBinary
+	keyCodec   Codec[K]
+	valueCodec Codec[V]
+
+	// Write-ahead log of a list opened with Open, and the policy for syncing it
+	wal          *wal
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+
+	// checkpoint serializes Checkpoint calls, which don't hold the list's lock while writing
+	checkpoint sync.Mutex
+
+	// Allocates new nodes in chunks, if set with SetArena
+	arena *arena[K, V]
+
+	// If unique is true, the list doesn't allow duplicate keys
+	unique bool
+
+	// dynamic is true if K is an interface type, in which case keys can be nil or have
+	// different dynamic types, and have to be checked before they are compared
+	dynamic bool
 
 	mutex sync.RWMutex
 }
 
-func New(compare Comparator) *Skiplist {
+// New creates a skiplist of interface{} keys and values, ordered by compare, which is either
+// a less-than Comparator such as BuiltinLessThan, or a three-way Compare such as BuiltinCompare.
+func New[C Comparer](compare C) *Skiplist[interface{}, interface{}] {
+	list := newSkiplist[interface{}, interface{}](toCompare(compare))
+	list.compareName = funcName(compare)
+	return list
+}
+
+// funcName returns the name of the function f, or an empty string if f is nil.
+func funcName(f any) string {
+	if v := reflect.ValueOf(f); v.Kind() == reflect.Func && !v.IsNil() {
+		return runtime.FuncForPC(v.Pointer()).Name()
+	}
+
+	return ""
+}
+
+// toCompare returns compare as a three-way comparator.
+func toCompare[C Comparer](compare C) (c Compare) {
+	switch compare := any(compare).(type) {
+	case Comparator:
+		if compare != nil {
+			c = FromLessThan(compare)
+		}
+	case Compare:
+		c = compare
+	}
+
+	return c
+}
+
+// NewOrdered creates a skiplist whose keys are sorted in ascending order using the < operator.
+func NewOrdered[K cmp.Ordered, V any]() *Skiplist[K, V] {
+	list := newSkiplist[K, V](func(k1, k2 K) (int, error) {
+		return cmp.Compare(k1, k2), nil
+	})
+	list.compareName = "cmp.Compare"
+	return list
+}
+
+// NewBytes creates a skiplist of []byte keys, sorted in ascending order using bytes.Compare.
+func NewBytes[V any]() *Skiplist[[]byte, V] {
+	list := newSkiplist[[]byte, V](func(k1, k2 []byte) (int, error) {
+		return bytes.Compare(k1, k2), nil
+	})
+	list.compareName = "bytes.Compare"
+	return list
+}
+
+// NewFunc creates a skiplist whose keys are sorted using compare, which returns a negative
+// number if k1 sorts before k2, a positive number if k1 sorts after k2, and zero otherwise.
+func NewFunc[K, V any](compare func(k1, k2 K) int) *Skiplist[K, V] {
+	list := newSkiplist[K, V](func(k1, k2 K) (int, error) {
+		return compare(k1, k2), nil
+	})
+	list.compareName = funcName(compare)
+	return list
+}
+
+func newSkiplist[K, V any](compare func(k1, k2 K) (int, error)) *Skiplist[K, V] {
 	l := DefaultMaxLevel
 	ip := int(math.Ceil(1 / float64(DefaultProbability)))
 
-	return &Skiplist{
+	list := &Skiplist[K, V]{
 		ip:            ip,
 		maxLevel:      l,
-		insertFingers: make([]*node, l),
-		selectFingers: make([]*node, l),
+		insertFingers: newFingers[K, V](l),
+		deleteFingers: newFingers[K, V](l),
 		level:         1,
 		count:         0,
 		compare:       compare,
-		headNode:      newNode(l),
+		keyCodec:      BuiltinCodec[K](),
+		valueCodec:    BuiltinCodec[V](),
+		dynamic:       reflect.TypeFor[K]().Kind() == reflect.Interface,
+		headNode:      newNode[K, V](l),
+	}
+
+	list.fingerPool.New = func() any {
+		return newFingers[K, V](l)
 	}
+
+	return list
+}
+
+// getFingers returns search fingers from the pool for a reader that doesn't have its own.
+func (this *Skiplist[K, V]) getFingers() *fingers[K, V] {
+	return this.fingerPool.Get().(*fingers[K, V])
 }
 
-func (this *Skiplist) SetCompare(compare Comparator) (err error) {
+func (this *Skiplist[K, V]) putFingers(f *fingers[K, V]) {
+	this.fingerPool.Put(f)
+}
+
+// isNil returns true if K is an interface type and key is nil.
+func (this *Skiplist[K, V]) isNil(key K) bool {
+	return this.dynamic && any(key) == nil
+}
+
+// sameType returns false if K is an interface type and the dynamic types of k1 and k2 differ.
+func (this *Skiplist[K, V]) sameType(k1, k2 K) bool {
+	return !this.dynamic || reflect.TypeOf(k1) == reflect.TypeOf(k2)
+}
+
+// SetCompare replaces the list's three-way comparator. Less-than comparators can be adapted
+// using FromLessThan.
+func (this *Skiplist[K, V]) SetCompare(compare func(k1, k2 K) (int, error)) (err error) {
 	if compare == nil {
 		return errors.New("skiplist/SetCompare: trying to set comparator to nil")
 	}
 	this.compare = compare
+	this.compareName = funcName(compare)
 	return nil
 }
 
-func (this *Skiplist) SetMaxLevel(l int) (err error) {
+func (this *Skiplist[K, V]) SetMaxLevel(l int) (err error) {
 	if l < 1 {
 		return errors.New("skiplist/SetCompare: max level must be greater than zero (0)")
 	}
-	this.maxLevel = l
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.setMaxLevel(l)
 	return nil
 }
 
-func (this *Skiplist) SetProbability(p float32) (err error) {
+// setMaxLevel sets the max level, and makes room for it in headNode. Fingers make room for the
+// new levels the next time they are used. The caller must hold the write lock.
+func (this *Skiplist[K, V]) setMaxLevel(l int) {
+	if n := l - len(this.headNode.next); n > 0 {
+		this.headNode.next = append(this.headNode.next, make([]*node[K, V], n)...)
+		this.headNode.span = append(this.headNode.span, make([]int, n)...)
+	}
+	this.maxLevel = l
+}
+
+func (this *Skiplist[K, V]) SetProbability(p float32) (err error) {
 	if p > 1 {
 		p = 1
 	}
@@ -116,44 +280,98 @@ func (this *Skiplist) SetProbability(p float32) (err error) {
 	return nil
 }
 
-func (this *Skiplist) Close() (err error) {
+// SetUnique sets whether the list enforces unique keys. In unique mode, Insert returns
+// ErrDuplicateKey if the key is already in the list. Unique mode can only be turned on while
+// the list is empty.
+func (this *Skiplist[K, V]) SetUnique(unique bool) (err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if unique && this.count > 0 {
+		return errors.New("skiplist/SetUnique: list is not empty")
+	}
+	this.unique = unique
 	return nil
 }
 
-func (this *Skiplist) Count() int {
+func (this *Skiplist[K, V]) Count() int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
 	return this.count
 }
 
-func (this *Skiplist) Level() int {
+func (this *Skiplist[K, V]) Level() int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
 	return this.level
 }
 
 // Choose the new node's level, branching with p (1/ip) probability, with no regards to N (size of list)
-func (this *Skiplist) newNodeLevel() int {
+func (this *Skiplist[K, V]) newNodeLevel() int {
+	return randomLevel(this.maxLevel, this.ip)
+}
+
+func randomLevel(maxLevel, ip int) int {
 	h := 1
 
-	for h < this.maxLevel && rand.Intn(this.ip) == 0 {
+	for h < maxLevel && rand.Intn(ip) == 0 {
 		h++
 	}
 
 	return h
 }
 
-func (this *Skiplist) updateSearchFingers(key interface{}, fingers []*node) (err error) {
+// fingers are the rightmost nodes at each level whose keys are before the last searched key.
+type fingers[K, V any] struct {
+	nodes []*node[K, V]
+
+	// The list version the fingers were positioned at
+	version uint64
+}
+
+func newFingers[K, V any](l int) *fingers[K, V] {
+	return &fingers[K, V]{
+		nodes: make([]*node[K, V], l),
+	}
+}
+
+// fit makes room for l levels in the fingers, if the list's max level was raised since they were
+// created.
+func (this *fingers[K, V]) fit(l int) {
+	if len(this.nodes) < l {
+		this.nodes = make([]*node[K, V], l)
+	}
+}
+
+// updateSearchFingers positions f on the rightmost nodes before key at each level, or the
+// rightmost nodes before or equal to key if after is true.
+func (this *Skiplist[K, V]) updateSearchFingers(key K, after bool, f *fingers[K, V]) (err error) {
+	f.fit(len(this.headNode.next))
+
+	// ahead returns true if a node that compares c to key is before the position
+	ahead := func(c int) bool {
+		return c < 0 || after && c == 0
+	}
+
 	startLevel := this.level - 1
 	startNode := this.headNode
+	fingers := f.nodes
 
-	if fingers[0] != nil && fingers[0].key != nil {
-		if less, err := this.compare(fingers[0].key, key); err != nil {
+	// Fingers positioned before the list was changed may point to deleted nodes, or may not be the
+	// rightmost nodes before the key anymore, so the search has to start from headNode
+	if f.version == this.version && fingers[0] != nil && fingers[0] != this.headNode {
+		if c, err := this.compare(fingers[0].key, key); err != nil {
 			return err
-		} else if less {
+		} else if ahead(c) {
 			// Move forward, find the highest level s.t. the next node's key < key
 			for l := 1; l < this.level; l++ {
-				if fingers[l].next[l] != nil && fingers[l].key != nil {
+				if fingers[l].next[l] != nil && fingers[l] != this.headNode {
 					// If the next node is not nil and fingers[l].key >= key
-					if less, err := this.compare(fingers[l].key, key); err != nil {
+					if c, err := this.compare(fingers[l].key, key); err != nil {
 						return err
-					} else if less == false {
+					} else if !ahead(c) {
 						startLevel = l - 1
 						startNode = fingers[l]
 						break
@@ -166,10 +384,10 @@ func (this *Skiplist) updateSearchFingers(key interface{}, fingers []*node) (err
 			for l := 1; l < this.level; l++ {
 				//log.Println("inside for loop, level =", l)
 				// fingers[l].key < key
-				if fingers[l].key != nil {
-					if less, err := this.compare(fingers[l].key, key); err != nil {
+				if fingers[l] != this.headNode {
+					if c, err := this.compare(fingers[l].key, key); err != nil {
 						return err
-					} else if less {
+					} else if ahead(c) {
 						startLevel = l
 						startNode = fingers[l]
 						break
@@ -195,9 +413,11 @@ func (this *Skiplist) updateSearchFingers(key interface{}, fingers []*node) (err
 
 			//log.Println("n != nil")
 			// If n.key >= key
-			if less, err := this.compare(n.key, key); err != nil {
+			if c, err := this.compare(n.key, key); err != nil {
+				// Some of the fingers have moved already, so they can't be reused
+				fingers[0] = nil
 				return err
-			} else if less == false {
+			} else if !ahead(c) {
 				// Found the first record that either has the same timestamp or greater at this level
 				// go to the next level down, and continue traversing
 				//log.Println("nt >= t, nt = ", nt.(int64))
@@ -212,80 +432,172 @@ func (this *Skiplist) updateSearchFingers(key interface{}, fingers []*node) (err
 		fingers[l] = p
 	}
 
+	f.version = this.version
+
 	return nil
 }
 
-func (this *Skiplist) Insert(key, value interface{}) (*node, error) {
-	if key == nil {
-		return nil, errors.New("skiplist/Insert: key is nil")
+func (this *Skiplist[K, V]) Insert(key K, value V) (*node[K, V], error) {
+	if err := this.checkKey("Insert", key); err != nil {
+		return nil, err
 	}
 
-	if this.compare == nil {
-		return nil, errors.New("skiplist/Insert: comparator is not set (== nil)")
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.unique {
+		if err := this.locateEnd(key); err != nil {
+			return nil, err
+		}
+	} else if p, err := this.locate(key); err != nil {
+		return nil, err
+	} else if p != nil {
+		return nil, ErrDuplicateKey
 	}
 
-	// Create new node
-	l := this.newNodeLevel()
-	n := newNode(l)
-	n.SetKey(key)
-	n.SetValue(value)
+	if err := this.logNode("Insert", walInsert, key, value); err != nil {
+		return nil, err
+	}
 
-	this.mutex.Lock()
-	defer this.mutex.Unlock()
+	return this.insert(key, value), nil
+}
+
+// checkKey returns an error if key is nil or the comparator is not set. name is the name of
+// the calling method, used in the error messages.
+func (this *Skiplist[K, V]) checkKey(name string, key K) error {
+	if this.isNil(key) {
+		return errors.New("skiplist/" + name + ": key is nil")
+	}
+
+	if this.compare == nil {
+		return errors.New("skiplist/" + name + ": comparator is not set (== nil)")
+	}
 
-	//log.Println("this.finger[0] =", this.insertFingers[0])
+	return nil
+}
+
+// locate positions the insert fingers right before key, and returns the first node with key, or
+// nil if there's no such node. The caller must hold the write lock.
+func (this *Skiplist[K, V]) locate(key K) (*node[K, V], error) {
+	//log.Println("this.finger[0] =", this.insertFingers.nodes[0])
 	// Find the position where we should insert the node by updating the search insertFingers using the key
 	// Search insertFingers will be updated with the rightmost element of each level that is left of the element
 	// that's greater than or equal to key.
 	// In other words, we are inserting the new node to the right of the search insertFingers.
-	if err := this.updateSearchFingers(key, this.insertFingers); err != nil {
+	if err := this.updateSearchFingers(key, false, this.insertFingers); err != nil {
 		return nil, errors.New("skiplist/insert: cannot find insert position, " + err.Error())
 	}
 
+	if n := this.skip(this.insertFingers.nodes[0].next[0], current, false); n != nil {
+		if c, err := this.compare(n.key, key); err != nil {
+			return nil, errors.New("skiplist/insert: error comparing keys; " + err.Error())
+		} else if c == 0 {
+			return n, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// locateEnd positions the insert fingers right after the nodes with key, where a duplicate key is
+// inserted, so that equal keys stay in the order they were inserted. The caller must hold the
+// write lock.
+func (this *Skiplist[K, V]) locateEnd(key K) error {
+	if err := this.updateSearchFingers(key, true, this.insertFingers); err != nil {
+		return errors.New("skiplist/insert: cannot find insert position, " + err.Error())
+	}
+
+	return nil
+}
+
+// insert creates a node for key and value, and links it right after the insert fingers, which
+// must have been positioned by locate or locateEnd. The caller must hold the write lock.
+func (this *Skiplist[K, V]) insert(key K, value V) *node[K, V] {
+	// Create new node
+	l := this.newNodeLevel()
+	n := this.newNode(l)
+	n.SetKey(inlineBytes(this.arena, key))
+	n.SetValue(inlineBytes(this.arena, value))
+
+	this.seq++
+	n.seq = this.seq
+
 	//log.Println("search insertFingers =", this.insertFingers)
 	// Raise the level of the skiplist if the new level is higher than the existing list level
 	// So for levels higher than the current list level, the previous node is headNode for that level
 	if this.level < l {
 		for i := this.level; i < l; i++ {
-			//log.Println("before ---- ", this.insertFingers)
-			this.insertFingers[i] = this.headNode
-			//log.Println("after  ---- ", this.insertFingers)
+			this.insertFingers.nodes[i] = this.headNode
 		}
 		this.level = l
-		//log.Println("new this.level =", l)
-		//log.Println("this.insertFingers =", this.insertFingers)
 	}
 
 	// Finally insert the node into the skiplist
-	for i := 0; i < l; i++ {
+	// d is the number of level 0 steps from the finger at level i to the new node
+	for i, d := 0, 1; i < l; i++ {
+		f := this.insertFingers.nodes[i]
+		if i > 0 {
+			d += distance(f, this.insertFingers.nodes[i-1], i-1)
+		}
+
 		// new node points forward to the previous node's next node
 		// previous node's next node points to the new node
-		n.next[i], this.insertFingers[i].next[i] = this.insertFingers[i].next[i], n
+		n.next[i], f.next[i] = f.next[i], n
+
+		// the previous node's span is split in two by the new node
+		if n.next[i] != nil {
+			n.span[i] = f.span[i] - d + 1
+		}
+		f.span[i] = d
+	}
+
+	// Higher levels skip over the new node, so their spans grow by one
+	for i := l; i < this.level; i++ {
+		if f := this.insertFingers.nodes[i]; f.next[i] != nil {
+			f.span[i]++
+		}
+	}
+
+	// Link the new node back to its previous node, and the next node back to the new node
+	n.prev = this.insertFingers.nodes[0]
+	if n.next[0] != nil {
+		n.next[0].prev = n
 	}
 
 	// Adding to the count
 	this.count++
 
-	return n, nil
+	this.version++
+	this.insertFingers.version = this.version
+
+	return n
+}
+
+// Iterate returns a lazy iterator over the whole list, which can be walked in either direction.
+func (this *Skiplist[K, V]) Iterate() *Iterator[K, V] {
+	return newRangeIterator(this, current, Unbounded[K](), Unbounded[K](), nil)
 }
 
 // Select a list of nodes that match the key. The results are stored in the array pointed to by results
-func (this *Skiplist) Select(key interface{}) (iter *Iterator, err error) {
-	return this.SelectRange(key, key)
+func (this *Skiplist[K, V]) Select(key K) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("Select", Inclusive(key), Inclusive(key), nil, current)
 }
 
-func (this *Skiplist) SelectRange(key1, key2 interface{}) (iter *Iterator, err error) {
-	if key1 == nil || key2 == nil {
-		return nil, errors.New("skiplist/SelectRange: key1 or key2 is nil")
-	}
+func (this *Skiplist[K, V]) SelectRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("SelectRange", Inclusive(key1), Inclusive(key2), nil, current)
+}
 
-	if reflect.TypeOf(key1) != reflect.TypeOf(key2) {
-		return nil, fmt.Errorf("skiplist/SelectRange: k1.(%s) and k2.(%s) have different types",
-			reflect.TypeOf(key1).Name(), reflect.TypeOf(key2).Name())
+// selectBounds returns a lazy iterator over the nodes between lo and hi that are visible as of
+// sequence number seq, using f to search for the start of the range, or fingers from the pool if
+// f is nil.
+func (this *Skiplist[K, V]) selectBounds(name string, lo, hi Bound[K], f *fingers[K, V], seq uint64) (iter *Iterator[K, V], err error) {
+	if err = this.checkBounds(name, lo, hi); err != nil {
+		return nil, err
 	}
 
-	if this.compare == nil {
-		return nil, errors.New("skiplist/SelectRange: comparator is not set (== nil)")
+	if f == nil {
+		f = this.getFingers()
+		defer this.putFingers(f)
 	}
 
 	this.mutex.RLock()
@@ -294,100 +606,258 @@ func (this *Skiplist) SelectRange(key1, key2 interface{}) (iter *Iterator, err e
 	// Walk the levels and nodes until we find the node at the lowest level (0) that the comparator returns false
 	// E.g., if comparator is BuiltinLessThan, then we find the node at the lowest level s.t. node.key < key
 	// Then we walk from there to find all the nodes that have node.key == key
-	// We keep track of the last touched nodes at each level as selectFingers, and then we re-use the selectFingers
+	// We keep track of the last touched nodes at each level as fingers, and then we re-use the fingers
 	// so that we can get O(log k) where k is the distance between last searched key and current search key
 	// -- ok, so all this is done by updateSearchFingers
 
-	if err = this.updateSearchFingers(key1, this.selectFingers); err != nil {
-		return nil, errors.New("skiplist/SelectRange: error selecting nodes, " + err.Error())
+	if err = this.seekLo(lo, f); err != nil {
+		return nil, errors.New("skiplist/" + name + ": error selecting nodes, " + err.Error())
 	}
 
-	iter = newIterator()
-	var res bool
-	for p := this.selectFingers[0].next[0]; p != nil; p = p.next[0] {
-		pk := p.GetKey()
-		if res, err = this.compare(pk, key2); err != nil {
-			// If there's error in comparing the keys, then return err
-			return nil, errors.New("skiplist/SelectRange: error comparing keys; " + err.Error())
-		} else if res || reflect.DeepEqual(pk, key2) {
-			iter.buf = append(iter.buf, p)
-			iter.count++
-		} else {
-			// Otherwise if the p.key is "after" key, after could mean greater or less, depending
-			// on the comparator, then we know we are done
-			break
+	// The iterator walks forward from the rightmost node that's before lo, and stops at the first
+	// node that's "after" hi, after could mean greater or less, depending on the comparator
+	return newRangeIterator(this, seq, lo, hi, f.nodes[0]), nil
+}
+
+// before returns the rightmost node with a key before key, or headNode if there's none, using
+// the fingers f. The caller must hold the lock.
+func (this *Skiplist[K, V]) before(key K, f *fingers[K, V]) (*node[K, V], error) {
+	if err := this.updateSearchFingers(key, false, f); err != nil {
+		return nil, errors.New("skiplist/seek: error finding node; " + err.Error())
+	}
+
+	return f.nodes[0], nil
+}
+
+// atOrBefore returns the rightmost node with a key before or equal to key, or headNode if
+// there's none. The caller must hold the lock.
+func (this *Skiplist[K, V]) atOrBefore(key K) (*node[K, V], error) {
+	p := this.headNode
+
+	for l := this.level - 1; l >= 0; l-- {
+		for n := p.next[l]; n != nil; n = n.next[l] {
+			if c, err := this.compare(n.key, key); err != nil {
+				return nil, errors.New("skiplist/seek: error finding node; " + err.Error())
+			} else if c > 0 {
+				break
+			}
+			p = n
 		}
 	}
 
-	return iter, nil
+	return p, nil
 }
 
-func (this *Skiplist) Delete(key interface{}) (iter *Iterator, err error) {
-	return this.DeleteRange(key, key)
+// last returns the last node in the list, or headNode if the list is empty. The caller must
+// hold the lock.
+func (this *Skiplist[K, V]) last() *node[K, V] {
+	p := this.headNode
+
+	for l := this.level - 1; l >= 0; l-- {
+		for p.next[l] != nil {
+			p = p.next[l]
+		}
+	}
+
+	return p
+}
+
+// skip returns the first node visible as of seq, starting from n and walking forward, or backward
+// if backward is true. It returns nil or headNode if there's no such node. The caller must hold
+// the lock.
+func (this *Skiplist[K, V]) skip(n *node[K, V], seq uint64, backward bool) *node[K, V] {
+	for n != nil && n != this.headNode && !n.visible(seq) {
+		if backward {
+			n = n.prev
+		} else {
+			n = n.next[0]
+		}
+	}
+
+	return n
 }
 
-func (this *Skiplist) DeleteRange(key1, key2 interface{}) (iter *Iterator, err error) {
-	if key1 == nil || key2 == nil {
-		return nil, errors.New("skiplist/DeleteRange: key1 or key2 is nil")
+// countRange returns the number of nodes between lo and hi that are visible as of seq.
+func (this *Skiplist[K, V]) countRange(lo, hi Bound[K], seq uint64) (count int, err error) {
+	f := this.getFingers()
+	defer this.putFingers(f)
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if err = this.seekLo(lo, f); err != nil {
+		return 0, err
 	}
 
-	if reflect.TypeOf(key1) != reflect.TypeOf(key2) {
-		return nil, fmt.Errorf("skiplist/DeleteRange: k1.(%s) and k2.(%s) have different types",
-			reflect.TypeOf(key1).Name(), reflect.TypeOf(key2).Name())
+	var ok bool
+	for p := f.nodes[0].next[0]; p != nil; p = p.next[0] {
+		if ok, err = this.beforeHi(hi, p.key); err != nil || !ok {
+			break
+		}
+
+		if p.visible(seq) {
+			count++
+		}
 	}
 
-	if this.compare == nil {
-		return nil, errors.New("skiplist/DeleteRange: comparator is not set (== nil)")
+	return count, err
+}
+
+func (this *Skiplist[K, V]) Delete(key K) (iter *Iterator[K, V], err error) {
+	return this.deleteBounds("Delete", Inclusive(key), Inclusive(key))
+}
+
+func (this *Skiplist[K, V]) DeleteRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.deleteBounds("DeleteRange", Inclusive(key1), Inclusive(key2))
+}
+
+func (this *Skiplist[K, V]) deleteBounds(name string, lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	if err = this.checkBounds(name, lo, hi); err != nil {
+		return nil, err
 	}
 
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
 
+	// The deletes are undone if comparing the keys fails partway, or the log can't be written,
+	// and only deletes that were applied are logged
+	var undo []change[K, V]
+	if iter, err = this.deleteRange(name, lo, hi, &undo); err == nil {
+		err = this.logDelete(name, lo, hi)
+	}
+
+	if err != nil {
+		this.rollback(undo)
+		return nil, err
+	}
+
+	return iter, nil
+}
+
+// deleteRange deletes the nodes between lo and hi, and returns them in an iterator. If undo is not
+// nil, each deleted node is recorded in it, so the deletes can be rolled back. The caller must
+// hold the write lock.
+func (this *Skiplist[K, V]) deleteRange(name string, lo, hi Bound[K], undo *[]change[K, V]) (iter *Iterator[K, V], err error) {
 	// Walk the levels and nodes until we find the node at the lowest level (0) that the comparator returns false
 	// E.g., if comparator is BuiltinLessThan, then we find the node at the lowest level s.t. node.key < key
 	// Then we walk from there to find all the nodes that have node.key == key
-	// We keep track of the last touched nodes at each level as selectFingers, and then we re-use the selectFingers
+	// We keep track of the last touched nodes at each level as deleteFingers, and then we re-use the deleteFingers
 	// so that we can get O(log k) where k is the distance between last searched key and current search key
 	// -- ok, so all this is done by updateSearchFingers
 
-	if err = this.updateSearchFingers(key1, this.selectFingers); err != nil {
-		return nil, errors.New("skiplist/DeleteRange: error finding node; " + err.Error())
+	if err = this.seekLo(lo, this.deleteFingers); err != nil {
+		return nil, errors.New("skiplist/" + name + ": error finding node; " + err.Error())
 	}
 
-	iter = newIterator()
-	var res bool
-	for p := this.selectFingers[0].next[0]; p != nil; p = p.next[0] {
-		pk := p.GetKey()
-		if res, err = this.compare(pk, key2); err != nil {
+	iter = newIterator[K, V]()
+	iter.compare = this.compare
+	var ok bool
+	for p := this.deleteFingers.nodes[0].next[0]; p != nil; p = p.next[0] {
+		if ok, err = this.beforeHi(hi, p.key); err != nil {
 			// If there's error in comparing the keys, then return err
-			return nil, errors.New("skiplist/DeleteRange: error comparing keys; " + err.Error())
-		} else if res || reflect.DeepEqual(pk, key2) {
-			iter.buf = append(iter.buf, p)
-			iter.count++
-
-			for i := 0; i < this.level; i++ {
-				if this.selectFingers[i].next[i] != p {
-					break
-				}
-				this.selectFingers[i].next[i] = p.next[i]
-			}
-
-			this.count--
-
-			for this.level > 1 && this.headNode.next[this.level-1] == nil {
-				this.level--
-			}
-		} else {
-			// Otherwise if the p.key is "after" key, after could mean greater or less, depending
+			return nil, errors.New("skiplist/" + name + ": error comparing keys; " + err.Error())
+		} else if !ok {
+			// Otherwise if the p.key is "after" hi, after could mean greater or less, depending
 			// on the comparator, then we know we are done
 			break
 		}
+
+		// Tombstones stay where they are, but the fingers have to move past them
+		if p.dead != 0 {
+			this.pass(this.deleteFingers, p)
+			continue
+		}
+
+		iter.buf = append(iter.buf, this.detach(p))
+		iter.count++
+
+		if undo != nil {
+			*undo = append(*undo, this.newChange(this.deleteFingers, p, false))
+		}
+		this.remove(this.deleteFingers, p)
 	}
 
 	return iter, nil
 }
 
-func (this *Skiplist) RealCount(i int) (c int) {
+// remove deletes p from the list. If a snapshot can see p, p is kept as a tombstone, and the
+// fingers move past it. Otherwise p is unlinked, and the fingers stay where they are. Either way,
+// the fingers must be the rightmost nodes before p at each level, and they are the rightmost
+// nodes before the node after p once it's removed. The caller must hold the write lock.
+func (this *Skiplist[K, V]) remove(f *fingers[K, V], p *node[K, V]) {
+	if !this.pinned(p) {
+		this.unlink(f, p)
+		return
+	}
+
+	// p doesn't count in any span anymore
+	for i := 0; i < this.level; i++ {
+		if q := f.nodes[i]; q.next[i] != nil {
+			q.span[i]--
+		}
+	}
+
+	this.seq++
+	p.dead = this.seq
+	this.tombstones = append(this.tombstones, p)
+	this.count--
+
+	this.pass(f, p)
+	this.version++
+	f.version = this.version
+}
+
+// pass moves the fingers past p, which must be the node right after the fingers at level 0.
+func (this *Skiplist[K, V]) pass(f *fingers[K, V], p *node[K, V]) {
+	for i := range p.next {
+		f.nodes[i] = p
+	}
+}
+
+// unlink removes p from the list. The fingers must be the rightmost nodes before p at each level,
+// and they are still valid once p is removed. The caller must hold the write lock.
+func (this *Skiplist[K, V]) unlink(f *fingers[K, V], p *node[K, V]) {
+	// Tombstones have already been taken out of the spans
+	w := 1
+	if p.dead != 0 {
+		w = 0
+	}
+
+	for i := 0; i < this.level; i++ {
+		q := f.nodes[i]
+		if q.next[i] == p {
+			q.span[i] += p.span[i] - w
+			q.next[i] = p.next[i]
+		} else if q.next[i] != nil {
+			q.span[i] -= w
+		}
+	}
+
+	if p.next[0] != nil {
+		p.next[0].prev = f.nodes[0]
+	}
+
+	this.count -= w
+	this.free(p)
+
+	for this.level > 1 && this.headNode.next[this.level-1] == nil {
+		this.level--
+	}
+
+	this.version++
+	f.version = this.version
+}
+
+// distance returns the number of level 0 steps from p to q, walking forward at level l.
+func distance[K, V any](p, q *node[K, V], l int) (d int) {
+	for ; p != q; p = p.next[l] {
+		d += p.span[l]
+	}
+
+	return
+}
+
+func (this *Skiplist[K, V]) RealCount(i int) (c int) {
 	for p := this.headNode.next[i]; p != nil; {
 		if p != nil {
 			//log.Println("node =", p.record)
@@ -399,7 +869,7 @@ func (this *Skiplist) RealCount(i int) (c int) {
 	return
 }
 
-func (this *Skiplist) PrintStats() {
+func (this *Skiplist[K, V]) PrintStats() {
 	fmt.Println("Real count   :", this.RealCount(0))
 	fmt.Println("Total levels :", this.Level())
 
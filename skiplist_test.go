@@ -15,9 +15,21 @@
 package skiplist
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -380,6 +392,2739 @@ func TestDeleteRangeInt2(t *testing.T) {
 	list.PrintStats()
 }
 
+func TestOrderedInt64(t *testing.T) {
+	count := 10000
+	list := NewOrdered[int64, int]()
+
+	for i := 0; i < count; i++ {
+		if _, err := list.Insert(int64(rand.Intn(count)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if list.RealCount(0) != count {
+		t.Fatal("Count not the same")
+	}
+
+	j := int64(-1)
+	for p := list.headNode.next[0]; p != nil; p = p.next[0] {
+		if j > p.key {
+			t.Fatal(j, " >", p.key)
+		}
+		j = p.key
+	}
+
+	rIter, err := list.SelectRange(100, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rIter.Next() {
+		if k := rIter.Key(); k < 100 || k > 2000 {
+			t.Fatal("key out of range", k)
+		}
+	}
+}
+
+func TestFuncStringDescending(t *testing.T) {
+	list := NewFunc[string, string](func(k1, k2 string) int {
+		return strings.Compare(k2, k1)
+	})
+
+	for _, k := range []string{"b", "d", "a", "c", "b"} {
+		if _, err := list.Insert(k, k+k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rIter, _ := list.SelectRange("d", "b")
+	keys := ""
+	for rIter.Next() {
+		if rIter.Value() != rIter.Key()+rIter.Key() {
+			t.Fatal("value mismatch for key", rIter.Key())
+		}
+		keys += rIter.Key()
+	}
+
+	if keys != "dcbb" {
+		t.Fatal("keys != dcbb:", keys)
+	}
+}
+
+func TestSelectCompareEquality(t *testing.T) {
+	type version struct {
+		major, minor int
+		label        *string
+	}
+
+	// Keys are equal if the major and minor numbers are equal, regardless of the label
+	list := NewFunc[version, int](func(k1, k2 version) int {
+		if c := k1.major - k2.major; c != 0 {
+			return c
+		}
+		return k1.minor - k2.minor
+	})
+
+	for i := 0; i < 10; i++ {
+		label := strconv.Itoa(i)
+		list.Insert(version{i % 3, 1, &label}, i)
+	}
+
+	rIter, err := list.Select(version{1, 1, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rIter.Count() != 3 {
+		t.Fatal("number of results != 3:", rIter.Count())
+	}
+}
+
+func TestThreeWayComparators(t *testing.T) {
+	for _, list := range []*Skiplist[interface{}, interface{}]{
+		New(BuiltinCompare),
+		New(Compare(FromLessThan(BuiltinLessThan))),
+	} {
+		for i := 0; i < 100; i++ {
+			list.Insert(i%10, i)
+		}
+
+		rIter, _ := list.SelectRange(3, 5)
+		if rIter.Count() != 30 {
+			t.Fatal("number of results != 30:", rIter.Count())
+		}
+
+		if _, err := list.Insert("a", 1); err == nil {
+			t.Fatal("expected error inserting a string into a list of ints")
+		}
+	}
+
+	list := New(BuiltinGreaterThan)
+	for i := 0; i < 100; i++ {
+		list.Insert(i%10, i)
+	}
+
+	rIter, _ := list.SelectRange(5, 3)
+	if rIter.Count() != 30 {
+		t.Fatal("number of results != 30:", rIter.Count())
+	}
+}
+
+type version struct {
+	major, minor int
+}
+
+func (this version) Less(other interface{}) bool {
+	o := other.(version)
+	return this.major < o.major || (this.major == o.major && this.minor < o.minor)
+}
+
+func TestBuiltinComparatorTypes(t *testing.T) {
+	type celsius float64
+	type id uint16
+	type name string
+	type flag bool
+	type blob []byte
+
+	now := time.Now()
+
+	// Each pair is in ascending order
+	pairs := [][2]interface{}{
+		{now, now.Add(time.Nanosecond)},
+		{[]byte("ab"), []byte("b")},
+		{false, true},
+		{big.NewInt(-5), big.NewInt(3)},
+		{new(big.Int).Lsh(big.NewInt(1), 100), new(big.Int).Lsh(big.NewInt(1), 101)},
+		{big.NewFloat(1.5), big.NewFloat(2.25)},
+		{celsius(-1.5), celsius(20)},
+		{id(7), id(300)},
+		{name("alice"), name("bob")},
+		{flag(false), flag(true)},
+		{blob("a"), blob("aa")},
+		{version{1, 9}, version{1, 10}},
+		{version{1, 10}, version{2, 0}},
+	}
+
+	for _, p := range pairs {
+		for _, k := range []struct {
+			k1, k2   interface{}
+			expected int
+		}{{p[0], p[1], -1}, {p[1], p[0], 1}, {p[0], p[0], 0}} {
+			k1, k2, expected := k.k1, k.k2, k.expected
+
+			c, err := BuiltinCompare(k1, k2)
+			lt, err1 := BuiltinLessThan(k1, k2)
+			gt, err2 := BuiltinGreaterThan(k1, k2)
+			eq, err3 := BuiltinEqual(k1, k2)
+			if err := errors.Join(err, err1, err2, err3); err != nil {
+				t.Fatal(err)
+			}
+
+			if c != expected || lt != (expected < 0) || gt != (expected > 0) || eq != (expected == 0) {
+				t.Fatalf("%T: comparing %v and %v = %d, %v, %v, %v, expected %d", k1, k1, k2, c, lt, gt, eq, expected)
+			}
+		}
+	}
+
+	// Lists of the new types sort in order
+	list := New(BuiltinLessThan)
+	for i := 0; i < 100; i++ {
+		list.Insert(now.Add(time.Duration(rand.Intn(1000))), i)
+	}
+
+	var prev time.Time
+	for k := range list.All() {
+		if k.(time.Time).Before(prev) {
+			t.Fatal("times are not sorted", k, prev)
+		}
+		prev = k.(time.Time)
+	}
+
+	if _, err := BuiltinCompare((*big.Int)(nil), big.NewInt(1)); err == nil {
+		t.Fatal("expected error comparing a nil *big.Int")
+	}
+
+	if _, err := BuiltinLessThan(struct{}{}, struct{}{}); err == nil {
+		t.Fatal("expected error comparing structs that aren't Lessers")
+	}
+
+	if _, err := BuiltinCodec[time.Time]().Append(nil, time.Now()); err == nil {
+		t.Fatal("expected error encoding a time.Time with the builtin codec")
+	}
+}
+
+func TestSelectRangeLazy(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 1000; i++ {
+		list.Insert(i, i)
+	}
+
+	rIter, _ := list.SelectRange(100, 899)
+	if rIter.buf != nil {
+		t.Fatal("lazy iterator buffered the range")
+	}
+
+	// Stop early, then insert a node ahead of the iterator
+	for i := 0; i < 10 && rIter.Next(); i++ {
+		if rIter.Key() != 100+i {
+			t.Fatal("key != ", 100+i, rIter.Key())
+		}
+	}
+	list.Insert(500, -1)
+
+	n := 10
+	for rIter.Next() {
+		n++
+	}
+	if n != 801 {
+		t.Fatal("number of results != 801:", n)
+	}
+
+	if rIter.Next() || rIter.Key() != 0 {
+		t.Fatal("iterator moved past the end of the range")
+	}
+
+	rIter.Rewind()
+	if !rIter.Next() || rIter.Key() != 100 {
+		t.Fatal("rewind did not go back to the first node")
+	}
+
+	if rIter.Count() != 801 {
+		t.Fatal("number of results != 801:", rIter.Count())
+	}
+}
+
+func TestSelectRangeLazyError(t *testing.T) {
+	failing := false
+	list := New(Compare(func(k1, k2 interface{}) (int, error) {
+		if failing && k1.(int) == 7 {
+			return 0, errors.New("cannot compare 7")
+		}
+		return k1.(int) - k2.(int), nil
+	}))
+
+	for i := 0; i < 10; i++ {
+		list.Insert(i, i)
+	}
+
+	rIter, err := list.SelectRange(2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing = true
+
+	n := 0
+	for rIter.Next() {
+		n++
+	}
+
+	if n != 5 || rIter.Err() == nil {
+		t.Fatal("expected 5 results and an error, got", n, rIter.Err())
+	}
+}
+
+func TestIteratePrev(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 1000; i++ {
+		list.Insert(rand.Intn(500)*2, i)
+	}
+	list.DeleteRange(100, 300)
+
+	prev := list.headNode
+	for p := list.headNode.next[0]; p != nil; p = p.next[0] {
+		if p.prev != prev {
+			t.Fatal("prev pointer of", p.key, "is not the previous node")
+		}
+		prev = p
+	}
+
+	rIter := list.Iterate()
+	n, k := 0, 1000
+	for ok := rIter.Last(); ok; ok = rIter.Prev() {
+		if rIter.Key() > k {
+			t.Fatal(rIter.Key(), " >", k)
+		}
+		k = rIter.Key()
+		n++
+	}
+
+	if n != list.Count() {
+		t.Fatal("number of results != ", list.Count(), n)
+	}
+
+	if rIter.Prev() || !rIter.Next() || rIter.Key() != list.headNode.next[0].key {
+		t.Fatal("iterator did not move forward to the first node")
+	}
+}
+
+func TestSelectRangeSeek(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 100; i++ {
+		list.Insert(i*10, i)
+	}
+
+	rIter, _ := list.SelectRange(200, 500)
+
+	if !rIter.Last() || rIter.Key() != 500 {
+		t.Fatal("last key != 500", rIter.Key())
+	}
+
+	if !rIter.Prev() || rIter.Key() != 490 {
+		t.Fatal("previous key != 490", rIter.Key())
+	}
+
+	if !rIter.SeekGE(255) || rIter.Key() != 260 {
+		t.Fatal("SeekGE(255) != 260", rIter.Key())
+	}
+
+	if !rIter.SeekLE(255) || rIter.Key() != 250 {
+		t.Fatal("SeekLE(255) != 250", rIter.Key())
+	}
+
+	if !rIter.SeekGE(0) || rIter.Key() != 200 {
+		t.Fatal("SeekGE(0) != 200", rIter.Key())
+	}
+
+	if rIter.Prev() {
+		t.Fatal("moved before the start of the range", rIter.Key())
+	}
+
+	if rIter.SeekGE(501) || rIter.Next() {
+		t.Fatal("moved past the end of the range", rIter.Key())
+	}
+
+	if !rIter.Prev() || rIter.Key() != 500 {
+		t.Fatal("previous key != 500", rIter.Key())
+	}
+
+	dIter, _ := list.DeleteRange(200, 500)
+	if !dIter.Last() || dIter.Key() != 500 || !dIter.Prev() || dIter.Key() != 490 {
+		t.Fatal("cannot walk deleted nodes backward", dIter.Key())
+	}
+
+	if !dIter.SeekLE(255) || dIter.Key() != 250 || !dIter.SeekGE(255) || dIter.Key() != 260 {
+		t.Fatal("cannot seek deleted nodes", dIter.Key())
+	}
+
+	if NewOrdered[int, int]().Iterate().Last() {
+		t.Fatal("empty list has a last node")
+	}
+}
+
+func TestRangeFunc(t *testing.T) {
+	list := NewOrdered[int, string]()
+	for i := 0; i < 100; i++ {
+		list.Insert(i, strconv.Itoa(i))
+	}
+
+	n := 0
+	for k, v := range list.All() {
+		if k != n || v != strconv.Itoa(n) {
+			t.Fatal("key/value != ", n, k, v)
+		}
+		n++
+	}
+
+	if n != 100 {
+		t.Fatal("number of results != 100:", n)
+	}
+
+	n = 99
+	for k := range list.Backward() {
+		if k != n {
+			t.Fatal("key != ", n, k)
+		}
+		n--
+	}
+
+	keys := []int{}
+	for k := range list.Range(10, 20) {
+		if k == 15 {
+			break
+		}
+		keys = append(keys, k)
+	}
+
+	if len(keys) != 5 || keys[0] != 10 || keys[4] != 14 {
+		t.Fatal("keys != [10, 14]:", keys)
+	}
+
+	// The read lock has to be released after the loop breaks
+	list.Insert(100, "100")
+
+	// The loop body can modify the list, including deleting the node it's on
+	n = 0
+	for k := range list.All() {
+		if k%2 == 0 {
+			list.Delete(k)
+		}
+		if k == 50 {
+			list.Insert(201, "201")
+		}
+		n++
+	}
+
+	if n != 102 || list.Count() != 51 {
+		t.Fatal("wrong number of nodes after deleting while iterating", n, list.Count())
+	}
+
+	n = 0
+	for k := range list.Backward() {
+		list.Delete(k)
+		n++
+	}
+
+	if n != 51 || list.Count() != 0 {
+		t.Fatal("wrong number of nodes after deleting backward", n, list.Count())
+	}
+
+	// Nodes with the same key as a deleted node are still returned, as long as the deleted node
+	// isn't the one the loop is on
+	for i := 0; i < 5; i++ {
+		list.Insert(1, strconv.Itoa(i))
+		list.Insert(2, strconv.Itoa(i))
+	}
+
+	values := []string{}
+	for k, v := range list.RangeBounds(Inclusive(1), Unbounded[int]()) {
+		if k == 1 && v == "4" {
+			list.Insert(0, "")
+		}
+		values = append(values, v)
+	}
+
+	if len(values) != 10 {
+		t.Fatal("wrong number of duplicates after inserting while iterating", values)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	list := NewOrdered[string, int]()
+	if err := list.SetUnique(true); err != nil {
+		t.Fatal(err)
+	}
+
+	list.Insert("a", 1)
+	list.Insert("c", 3)
+
+	if _, err := list.Insert("a", 2); err != ErrDuplicateKey {
+		t.Fatal("expected ErrDuplicateKey, got", err)
+	}
+
+	if replaced, _ := list.Upsert("a", 10); !replaced {
+		t.Fatal("Upsert did not replace the value of a")
+	}
+
+	if replaced, _ := list.Upsert("b", 2); replaced {
+		t.Fatal("Upsert replaced a value for b")
+	}
+
+	if ok, _ := list.Update("c", func(v int) int { return v * 10 }); !ok {
+		t.Fatal("Update did not find c")
+	}
+
+	if ok, _ := list.Update("d", func(v int) int { return v * 10 }); ok {
+		t.Fatal("Update found d")
+	}
+
+	if inserted, _ := list.InsertIfAbsent("c", 0); inserted {
+		t.Fatal("InsertIfAbsent inserted c twice")
+	}
+
+	if inserted, _ := list.InsertIfAbsent("d", 4); !inserted {
+		t.Fatal("InsertIfAbsent did not insert d")
+	}
+
+	for k, v := range map[string]int{"a": 10, "b": 2, "c": 30, "d": 4} {
+		if value, ok, _ := list.Get(k); !ok || value != v {
+			t.Fatal("value of", k, "!=", v, value)
+		}
+	}
+
+	if _, ok, _ := list.Get("e"); ok {
+		t.Fatal("found e")
+	}
+
+	if list.Count() != 4 || list.RealCount(0) != 4 {
+		t.Fatal("count != 4", list.Count())
+	}
+
+	if err := list.SetUnique(true); err == nil {
+		t.Fatal("turned on unique mode for a list that's not empty")
+	}
+}
+
+// checkSpans verifies the span of every link at every level of the list. Tombstones have the same
+// index as the live node before them.
+func checkSpans[K, V any](t *testing.T, list *Skiplist[K, V]) {
+	index := map[*node[K, V]]int{list.headNode: 0}
+	i := 0
+	for p := list.headNode.next[0]; p != nil; p = p.next[0] {
+		if p.dead == 0 {
+			i++
+		}
+		index[p] = i
+	}
+
+	for l := 0; l < list.level; l++ {
+		for p := list.headNode; p.next[l] != nil; p = p.next[l] {
+			if p.span[l] != index[p.next[l]]-index[p] {
+				t.Fatal("span at level", l, "!=", index[p.next[l]]-index[p], p.span[l])
+			}
+		}
+	}
+}
+
+func TestRankAt(t *testing.T) {
+	count := 5000
+	list := NewOrdered[int, int]()
+	keys := []int{}
+
+	for i := 0; i < count; i++ {
+		k := rand.Intn(count)
+		list.Insert(k, i)
+		keys = append(keys, k)
+	}
+	checkSpans(t, list)
+
+	list.DeleteRange(1000, 1999)
+	for i := 0; i < 500; i++ {
+		list.DeleteAt(rand.Intn(list.Count()))
+	}
+	for i := 0; i < 1000; i++ {
+		list.Insert(rand.Intn(count), i)
+	}
+	checkSpans(t, list)
+
+	keys = keys[:0]
+	for k := range list.All() {
+		keys = append(keys, k)
+	}
+
+	for i, k := range keys {
+		if key, _, ok := list.At(i); !ok || key != k {
+			t.Fatal("key at", i, "!=", k, key)
+		}
+
+		if i == 0 || keys[i-1] != k {
+			if rank, _ := list.Rank(k); rank != i {
+				t.Fatal("rank of", k, "!=", i, rank)
+			}
+		}
+	}
+
+	if rank, _ := list.Rank(count); rank != -1 {
+		t.Fatal("rank of a missing key != -1", rank)
+	}
+
+	if _, _, ok := list.At(len(keys)); ok {
+		t.Fatal("found a node past the end of the list")
+	}
+
+	rIter := list.RangeByIndex(100, 110)
+	for i := 100; rIter.Next(); i++ {
+		if rIter.Key() != keys[i] {
+			t.Fatal("key at", i, "!=", keys[i], rIter.Key())
+		}
+	}
+
+	if rIter.Count() != 10 || list.RangeByIndex(len(keys)-5, len(keys)+5).Count() != 5 {
+		t.Fatal("wrong number of results for index ranges")
+	}
+
+	if k, _, ok, err := list.DeleteAt(0); err != nil || !ok || k != keys[0] || list.Count() != len(keys)-1 {
+		t.Fatal("DeleteAt(0) did not delete", keys[0], k)
+	}
+	checkSpans(t, list)
+}
+
+func TestFingersAfterChanges(t *testing.T) {
+	list := NewOrdered[int, int]()
+	list.Insert(1, 1)
+	list.Insert(50, 1)
+	list.Select(50)
+
+	// The select fingers were positioned when the list had fewer levels, and the insert
+	// fingers may point to deleted nodes, so both have to be repositioned.
+	for i := 0; i < 10000; i++ {
+		list.Insert(i+100, i)
+	}
+	list.DeleteRange(5000, 6000)
+	list.Insert(5500, 0)
+
+	if rIter, _ := list.Select(0); rIter.Count() != 0 {
+		t.Fatal("found key 0")
+	}
+
+	if rIter, _ := list.SelectRange(4000, 7000); rIter.Count() != 2001 {
+		t.Fatal("number of results != 2001:", rIter.Count())
+	}
+	checkSpans(t, list)
+}
+
+func TestNearest(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 1; i <= 5; i++ {
+		list.Insert(i*10, i)
+		list.Insert(i*10, i)
+	}
+
+	tests := []struct {
+		method     func(int) (int, int, bool, error)
+		key, found int
+	}{
+		{list.Floor, 30, 30},
+		{list.Floor, 35, 30},
+		{list.Floor, 5, -1},
+		{list.Floor, 60, 50},
+		{list.Ceiling, 30, 30},
+		{list.Ceiling, 35, 40},
+		{list.Ceiling, 5, 10},
+		{list.Ceiling, 55, -1},
+		{list.Lower, 30, 20},
+		{list.Lower, 35, 30},
+		{list.Lower, 10, -1},
+		{list.Higher, 30, 40},
+		{list.Higher, 25, 30},
+		{list.Higher, 50, -1},
+	}
+
+	for i, test := range tests {
+		k, _, ok, err := test.method(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (test.found == -1 && ok) || (test.found != -1 && (!ok || k != test.found)) {
+			t.Fatal("test", i, "key", test.key, "found", k, ok, "expected", test.found)
+		}
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	list := NewOrdered[int, int]()
+
+	if _, _, ok, err := list.PopMin(); ok || err != nil {
+		t.Fatal("popped a node from an empty list")
+	}
+
+	count := 2000
+	for i := 0; i < count; i++ {
+		list.Insert(rand.Intn(100), i)
+	}
+
+	min, _, _ := list.Min()
+	max, _, _ := list.Max()
+	if k, _, ok, err := list.PopMin(); err != nil || !ok || k != min {
+		t.Fatal("PopMin != Min", k, min)
+	}
+	if k, _, ok, err := list.PopMax(); err != nil || !ok || k != max {
+		t.Fatal("PopMax != Max", k, max)
+	}
+
+	prev := -1
+	for n := 2; list.Count() > 0; n++ {
+		rIter, err := list.PopN(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rIter.Next() {
+			if rIter.Key() < prev {
+				t.Fatal(rIter.Key(), " <", prev)
+			}
+			prev = rIter.Key()
+		}
+
+		if _, _, ok, _ := list.PopMax(); ok {
+			checkSpans(t, list)
+		}
+	}
+
+	if list.Level() != 1 || list.headNode.next[0] != nil {
+		t.Fatal("list is not empty after popping all the nodes")
+	}
+
+	// Equal priorities come out first in, first out, including the ones inserted by a batch and
+	// those inserted while others are popped
+	for i := 0; i < 3; i++ {
+		list.Insert(1, i)
+	}
+
+	batch := list.NewBatch()
+	batch.Insert(1, 3)
+	batch.Insert(0, -1)
+	batch.Insert(1, 4)
+	list.Apply(batch)
+
+	for i := -1; i < 5; i++ {
+		if _, v, _, _ := list.PopMin(); v != i {
+			t.Fatal("PopMin != ", i, v)
+		}
+
+		if i == 2 {
+			list.Insert(1, 5)
+			list.Insert(1, 6)
+		}
+	}
+
+	pIter, _ := list.PopN(3)
+	for _, v := range []int{5, 6} {
+		if !pIter.Next() || pIter.Value() != v {
+			t.Fatal("PopN didn't pop in insert order", v, pIter.Value())
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		list.Insert(2, i)
+	}
+	if _, v, _, _ := list.PopMax(); v != 2 {
+		t.Fatal("PopMax didn't return the newest of the equal keys", v)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 10; i++ {
+		list.Insert(i, i)
+		list.Insert(i, i)
+	}
+
+	tests := []struct {
+		lo, hi      Bound[int]
+		first, last int
+		count       int
+	}{
+		{Inclusive(2), Inclusive(4), 2, 4, 6},
+		{Inclusive(2), Exclusive(4), 2, 3, 4},
+		{Exclusive(2), Inclusive(4), 3, 4, 4},
+		{Exclusive(2), Exclusive(4), 3, 3, 2},
+		{Exclusive(7), Unbounded[int](), 8, 9, 4},
+		{Unbounded[int](), Exclusive(1), 0, 0, 2},
+		{Unbounded[int](), Unbounded[int](), 0, 9, 20},
+		{Exclusive(3), Exclusive(4), 0, 0, 0},
+	}
+
+	for i, test := range tests {
+		rIter, err := list.SelectBounds(test.lo, test.hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rIter.Count() != test.count {
+			t.Fatal("test", i, "count != ", test.count, rIter.Count())
+		}
+
+		n := 0
+		for k := range list.RangeBounds(test.lo, test.hi) {
+			if (n == 0 && k != test.first) || (n == test.count-1 && k != test.last) {
+				t.Fatal("test", i, "unexpected key", k, "at", n)
+			}
+			n++
+		}
+
+		if n != test.count {
+			t.Fatal("test", i, "range count != ", test.count, n)
+		}
+
+		if test.count > 0 && (!rIter.Last() || rIter.Key() != test.last || !rIter.First() || rIter.Key() != test.first) {
+			t.Fatal("test", i, "first or last key is wrong", rIter.Key())
+		}
+	}
+
+	rIter, _ := list.SelectBounds(Exclusive(2), Exclusive(8))
+	if !rIter.SeekGE(0) || rIter.Key() != 3 || !rIter.SeekLE(100) || rIter.Key() != 7 {
+		t.Fatal("seek outside of the range did not stop at the bounds", rIter.Key())
+	}
+
+	dIter, _ := list.DeleteBounds(Exclusive(2), Exclusive(8))
+	if dIter.Count() != 10 || list.Count() != 10 {
+		t.Fatal("wrong number of deleted nodes", dIter.Count(), list.Count())
+	}
+	checkSpans(t, list)
+
+	dIter, _ = list.DeleteBounds(Unbounded[int](), Unbounded[int]())
+	if dIter.Count() != 10 || list.Count() != 0 {
+		t.Fatal("wrong number of deleted nodes", dIter.Count(), list.Count())
+	}
+}
+
+// Run with -race to check that concurrent readers don't share any state.
+func TestConcurrentReads(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 10000; i++ {
+		list.Insert(rand.Intn(10000), i)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 9)
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			finger := list.NewFinger()
+			for i := 0; i < 500; i++ {
+				k := (g*1000 + i*7) % 10000
+
+				rIter, err := list.SelectRange(k, k+10)
+				if g%2 == 0 {
+					rIter, err = finger.SelectRange(k, k+10)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				for rIter.Next() {
+					rIter.Value()
+					if rIter.Key() < k || rIter.Key() > k+10 {
+						errs <- fmt.Errorf("key %d out of range [%d, %d]", rIter.Key(), k, k+10)
+						return
+					}
+				}
+
+				list.Floor(k)
+				list.Get(k)
+				finger.Get(k)
+				list.Rank(k)
+				rIter.SeekGE(k + 5)
+				rIter.Last()
+				for range list.Range(k, k+5) {
+				}
+			}
+		}(g)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			if _, err := list.Insert(rand.Intn(10000), i); err != nil {
+				errs <- err
+				return
+			}
+			list.Delete(rand.Intn(10000))
+			list.Upsert(rand.Intn(10000), i)
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	checkSpans(t, list)
+}
+
+// checkSnapshot verifies that the snapshot has the keys 0 to 999, with the keys as values.
+func checkSnapshot(t *testing.T, snap *Snapshot[int, int]) {
+	rIter, err := snap.SelectRange(-10000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	for ; rIter.Next(); i++ {
+		if rIter.Key() != i || rIter.Value() != i {
+			t.Fatal("snapshot node", i, "!=", rIter.Key(), rIter.Value())
+		}
+	}
+
+	if i != 1000 || rIter.Count() != 1000 || snap.Count() != 1000 {
+		t.Fatal("snapshot count != 1000", i, rIter.Count(), snap.Count())
+	}
+
+	for i = 999; rIter.Prev(); i-- {
+		if rIter.Key() != i {
+			t.Fatal("snapshot node", i, "!=", rIter.Key())
+		}
+	}
+
+	if rIter, _ = snap.Select(500); !rIter.Next() || rIter.Value() != 500 || rIter.Next() {
+		t.Fatal("snapshot select 500 != 500", rIter.Value())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 1000; i++ {
+		list.Insert(i, i)
+	}
+
+	snap := list.Snapshot()
+
+	list.DeleteRange(0, 99)
+	list.Upsert(500, -1)
+	list.Update(600, func(v int) int { return -v })
+	list.PopMin()
+	list.PopMax()
+	list.DeleteAt(10)
+	for i := 2000; i < 2100; i++ {
+		list.Insert(i, i)
+	}
+	list.Upsert(500, -2)
+
+	checkSnapshot(t, snap)
+	checkSpans(t, list)
+
+	if list.Count() != 1000-103+100 {
+		t.Fatal("count !=", 1000-103+100, list.Count())
+	}
+
+	if v, _, _ := list.Get(500); v != -2 {
+		t.Fatal("value of 500 != -2", v)
+	}
+
+	if k, _, _ := list.Min(); k != 101 {
+		t.Fatal("min != 101", k)
+	}
+
+	if k, _, _ := list.Max(); k != 2099 {
+		t.Fatal("max != 2099", k)
+	}
+
+	keys := []int{}
+	for k := range list.All() {
+		keys = append(keys, k)
+	}
+
+	if len(keys) != list.Count() {
+		t.Fatal("number of keys != count", len(keys), list.Count())
+	}
+
+	for i, k := range keys {
+		if r, _ := list.Rank(k); r != i {
+			t.Fatal("rank of", k, "!=", i, r)
+		}
+
+		if key, _, _ := list.At(i); key != k {
+			t.Fatal("key at", i, "!=", k, key)
+		}
+	}
+
+	// Writers don't change what the snapshot sees
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			k := rand.Intn(1000)
+			list.Upsert(k, -k)
+			list.Delete(rand.Intn(1000))
+			list.Insert(rand.Intn(1000), 0)
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		checkSnapshot(t, snap)
+	}
+	wg.Wait()
+
+	snap2 := list.Snapshot()
+	list.DeleteRange(-10000, 10000)
+
+	if list.Count() != 0 {
+		t.Fatal("count != 0", list.Count())
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the tombstones the second snapshot sees are left
+	if list.RealCount(0) != snap2.Count() {
+		t.Fatal("number of tombstones !=", snap2.Count(), list.RealCount(0))
+	}
+	checkSpans(t, list)
+
+	if err := snap2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if list.RealCount(0) != 0 || len(list.tombstones) != 0 {
+		t.Fatal("tombstones were not reclaimed", list.RealCount(0), len(list.tombstones))
+	}
+
+	if _, err := snap.Select(1); err == nil {
+		t.Fatal("expected error selecting from a closed snapshot")
+	}
+
+	if err := snap.Close(); err == nil {
+		t.Fatal("expected error closing a snapshot twice")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	list := New(BuiltinCompare)
+	for i := 0; i < 1000; i++ {
+		list.Insert(i, i)
+	}
+
+	batch := list.NewBatch()
+	batch.DeleteRange(100, 199)
+	batch.Insert(150, "new")
+	batch.Delete(500)
+	batch.Insert(500, "new")
+	batch.Insert(1000, "new")
+
+	if err := list.Apply(batch); err != nil {
+		t.Fatal(err)
+	}
+	checkSpans(t, list)
+
+	if list.Count() != 1000-100+2 {
+		t.Fatal("count !=", 1000-100+2, list.Count())
+	}
+
+	for _, k := range []int{150, 500, 1000} {
+		if v, _, _ := list.Get(k); v != "new" {
+			t.Fatal("value of", k, "!= new", v)
+		}
+	}
+
+	keys := func() (keys []interface{}) {
+		for k, v := range list.All() {
+			keys = append(keys, k, v)
+		}
+		return
+	}
+	before := fmt.Sprint(keys())
+	snap := list.Snapshot()
+
+	// The string key can't be compared with the int keys, which fails the batch after the
+	// inserts and deletes before it are done
+	batch.Reset()
+	batch.Insert(-1, -1)
+	batch.DeleteRange(0, 99)
+	batch.Insert(5000, 5000)
+	batch.Delete(998)
+	batch.Insert("a", 0)
+	batch.Insert(2, 2)
+
+	if err := list.Apply(batch); err == nil {
+		t.Fatal("expected error applying the batch")
+	}
+	checkSpans(t, list)
+
+	if after := fmt.Sprint(keys()); after != before || list.Count() != 1000-100+2 {
+		t.Fatal("batch was not rolled back", list.Count())
+	}
+
+	if len(list.tombstones) != 0 {
+		t.Fatal("tombstones were not rolled back", len(list.tombstones))
+	}
+	snap.Close()
+
+	// In unique mode, a duplicate key fails the batch
+	ulist := NewOrdered[int, int]()
+	ulist.SetUnique(true)
+	ulist.Insert(1, 1)
+
+	ubatch := ulist.NewBatch()
+	ubatch.Delete(1)
+	ubatch.Insert(1, 2)
+	ubatch.Insert(3, 3)
+	ubatch.Insert(1, 4)
+
+	if err := ulist.Apply(ubatch); err != ErrDuplicateKey {
+		t.Fatal("expected ErrDuplicateKey, got", err)
+	}
+
+	if v, _, _ := ulist.Get(1); v != 1 || ulist.Count() != 1 {
+		t.Fatal("batch was not rolled back", v, ulist.Count())
+	}
+
+	// Comparing 50 with 80 fails, so deleting up to 80 fails partway, after 10 to 49 are deleted.
+	// Neither the delete nor the batch is logged, so they aren't applied when the log is replayed
+	// with a comparator that doesn't fail.
+	dir := t.TempDir()
+	fail := false
+	compare := Compare(func(k1, k2 interface{}) (int, error) {
+		if fail && (k1 == 50 && k2 == 80 || k1 == 80 && k2 == 50) {
+			return 0, errors.New("can't compare 50 and 80")
+		}
+		return BuiltinCompare(k1, k2)
+	})
+
+	open := func() *Skiplist[interface{}, interface{}] {
+		list := New(compare)
+		list.SetCodecs(BuiltinCodec[interface{}](), BuiltinCodec[interface{}]())
+		list.SetSyncPolicy(SyncNever, 0)
+		if err := list.Open(dir); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	flist := open()
+	for i := 0; i < 100; i++ {
+		flist.Insert(i, i)
+	}
+
+	fail = true
+	if _, err := flist.DeleteRange(10, 80); err == nil {
+		t.Fatal("expected error deleting the range")
+	}
+	checkSpans(t, flist)
+
+	if _, ok, _ := flist.Get(10); !ok || flist.Count() != 100 {
+		t.Fatal("delete was not rolled back", flist.Count())
+	}
+
+	fbatch := flist.NewBatch()
+	fbatch.Insert(200, 200)
+	fbatch.DeleteRange(10, 80)
+
+	if err := flist.Apply(fbatch); err == nil {
+		t.Fatal("expected error applying the batch")
+	}
+	flist.Close()
+
+	fail = false
+	flist = open()
+
+	if _, ok, _ := flist.Get(200); ok || flist.Count() != 100 {
+		t.Fatal("rolled back writes were replayed", flist.Count())
+	}
+
+	// The log only has the deletes and batches that applied, so if one fails when it's replayed,
+	// the comparator changed, and Open fails. The checkpoint leaves them alone in the log.
+	for _, write := range []func(*Skiplist[interface{}, interface{}]) error{
+		func(list *Skiplist[interface{}, interface{}]) error {
+			_, err := list.DeleteRange(10, 80)
+			return err
+		},
+		func(list *Skiplist[interface{}, interface{}]) error {
+			batch := list.NewBatch()
+			batch.DeleteRange(10, 80)
+			return list.Apply(batch)
+		},
+	} {
+		for i := 10; i <= 80; i++ {
+			flist.Insert(i, i)
+		}
+
+		if err := flist.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := write(flist); err != nil {
+			t.Fatal(err)
+		}
+		flist.Close()
+
+		fail = true
+		if New(compare).Open(dir) == nil {
+			t.Fatal("expected error replaying a write that fails")
+		}
+
+		fail = false
+		flist = open()
+	}
+
+	// nil values are logged too
+	if _, err := flist.Insert(1000, nil); err != nil {
+		t.Fatal(err)
+	}
+	flist.Close()
+
+	flist = open()
+	defer flist.Close()
+
+	if v, ok, _ := flist.Get(1000); !ok || v != nil {
+		t.Fatal("nil value was not replayed", v, ok)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	list := NewOrdered[int, string]()
+	for i := 0; i < 100; i++ {
+		list.Insert(i, strconv.Itoa(i))
+	}
+
+	t1 := list.Begin()
+	t1.Insert(5, "t1")
+	t1.Delete(3)
+
+	// The transaction reads its own writes, after the nodes with the same key, as they will be
+	// once they're committed
+	rIter, err := t1.SelectRange(0, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for rIter.Next() {
+		keys = append(keys, rIter.Value())
+	}
+
+	if strings.Join(keys, ",") != "0,1,2,4,5,t1,6,7,8,9" {
+		t.Fatal("transaction doesn't see its own writes", keys)
+	}
+
+	// Writes outside the ranges the transaction read don't conflict
+	list.Insert(50, "50")
+
+	if v, _, _ := list.Get(5); v != "5" {
+		t.Fatal("uncommitted write is visible", v)
+	}
+
+	if err = t1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if iter, _ := list.Select(5); iter.Count() != 2 || !iter.Last() || iter.Value() != "t1" {
+		t.Fatal("committed write is not visible", iter.Value())
+	}
+
+	if err = t1.Insert(1, "1"); err == nil {
+		t.Fatal("expected error using a committed transaction")
+	}
+
+	// Write skew: each transaction reads both keys and deletes one of them
+	t2, t3 := list.Begin(), list.Begin()
+	t2.Get(20)
+	t2.Get(21)
+	t3.Get(20)
+	t3.Get(21)
+	t2.Delete(20)
+	t3.Delete(21)
+
+	if err = t2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = t3.Commit(); err != ErrConflict {
+		t.Fatal("expected ErrConflict, got", err)
+	}
+
+	if _, ok, _ := list.Get(21); !ok {
+		t.Fatal("conflicting transaction was committed")
+	}
+
+	// Writes by the list's own methods conflict as well
+	t4 := list.Begin()
+	t4.SelectRange(60, 70)
+	t4.Insert(65, "t4")
+	list.Upsert(62, "upserted")
+
+	if err = t4.Commit(); err != ErrConflict {
+		t.Fatal("expected ErrConflict, got", err)
+	}
+
+	t5 := list.Begin()
+	t5.Insert(1000, "t5")
+	t5.Rollback()
+
+	if _, ok, _ := list.Get(1000); ok {
+		t.Fatal("rolled back transaction was committed")
+	}
+
+	if list.RealCount(0) != list.Count() {
+		t.Fatal("tombstones were not reclaimed", list.RealCount(0), list.Count())
+	}
+}
+
+// The reads of a transaction merge its writes with the snapshot as it goes, in the same order as a
+// copy of the range with the writes applied to it, in both directions
+func TestTxnMerge(t *testing.T) {
+	list := NewOrdered[int, string]()
+	for i := 0; i < 500; i++ {
+		list.Insert(rand.Intn(100), strconv.Itoa(i))
+	}
+
+	txn := list.Begin()
+	for i := 0; i < 50; i++ {
+		k := rand.Intn(110) - 5
+		switch rand.Intn(3) {
+		case 0:
+			txn.DeleteBounds(Exclusive(k), Inclusive(k+rand.Intn(5)))
+		default:
+			txn.Insert(k, "t"+strconv.Itoa(i))
+		}
+	}
+
+	model := NewOrdered[int, string]()
+	for k, v := range list.All() {
+		model.Insert(k, v)
+	}
+	if err := model.Apply(txn.writes); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		k1 := rand.Intn(120) - 10
+		k2 := k1 + rand.Intn(40)
+
+		iter, err := txn.SelectRange(k1, k2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := model.SelectRange(k1, k2)
+
+		if iter.Count() != expected.Count() {
+			t.Fatal("count of", k1, k2, "!=", expected.Count(), iter.Count())
+		}
+
+		for j := 0; j < 200; j++ {
+			var ok, eok bool
+			switch k := rand.Intn(120) - 10; rand.Intn(8) {
+			case 0:
+				ok, eok = iter.SeekGE(k), expected.SeekGE(k)
+			case 1:
+				ok, eok = iter.SeekLE(k), expected.SeekLE(k)
+			case 2:
+				ok, eok = iter.First(), expected.First()
+			case 3:
+				ok, eok = iter.Last(), expected.Last()
+			case 4, 5:
+				ok, eok = iter.Prev(), expected.Prev()
+			default:
+				ok, eok = iter.Next(), expected.Next()
+			}
+
+			if ok != eok || iter.Key() != expected.Key() || iter.Value() != expected.Value() {
+				t.Fatal("merged iterator differs from the copy", ok, eok, iter.Key(), expected.Key(), iter.Value(), expected.Value())
+			}
+		}
+
+		if iter.Err() != nil {
+			t.Fatal(iter.Err())
+		}
+	}
+
+	// Reads see the writes made since they started, as lazy iterators do
+	iter, _ := txn.SelectRange(200, 300)
+	txn.Insert(250, "new")
+	if !iter.Next() || iter.Key() != 250 || iter.Next() {
+		t.Fatal("expected the transaction's new insert")
+	}
+	txn.Rollback()
+
+	// Keys that can't be compared fail the write, rather than the reads or the commit
+	ilist := New(BuiltinLessThan)
+	ilist.Insert(1, 1)
+	itxn := ilist.Begin()
+	itxn.Insert(2, 2)
+	if itxn.Insert("a", 3) == nil {
+		t.Fatal("expected error inserting a key that can't be compared")
+	}
+
+	if iter, err := itxn.SelectRange(0, 5); err != nil || iter.Count() != 2 || iter.Err() != nil {
+		t.Fatal("unexpected read after a failed insert", err)
+	}
+
+	if err := itxn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxnConcurrent(t *testing.T) {
+	accounts, goroutines, transfers := 10, 8, 200
+
+	list := NewOrdered[int, int]()
+	list.SetUnique(true)
+	for i := 0; i < accounts; i++ {
+		list.Insert(i, 100)
+	}
+
+	transfer := func(from, to int) error {
+		txn := list.Begin()
+
+		v1, _, _ := txn.Get(from)
+		v2, _, _ := txn.Get(to)
+
+		txn.Delete(from)
+		txn.Insert(from, v1-1)
+		txn.Delete(to)
+		txn.Insert(to, v2+1)
+
+		return txn.Commit()
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < transfers; i++ {
+				from, to := rand.Intn(accounts), rand.Intn(accounts)
+				if from == to {
+					continue
+				}
+
+				for transfer(from, to) == ErrConflict {
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sum := 0
+	for _, v := range list.All() {
+		sum += v
+	}
+
+	if sum != accounts*100 || list.Count() != accounts {
+		t.Fatal("sum of balances !=", accounts*100, sum, list.Count())
+	}
+}
+
+type point struct {
+	X, Y int
+}
+
+type pointCodec struct{}
+
+func (pointCodec) Append(buf []byte, p point) ([]byte, error) {
+	return fmt.Appendf(buf, "%d,%d", p.X, p.Y), nil
+}
+
+func (pointCodec) Decode(data []byte) (p point, err error) {
+	_, err = fmt.Sscanf(string(data), "%d,%d", &p.X, &p.Y)
+	return
+}
+
+func TestMarshalBinary(t *testing.T) {
+	list := NewOrdered[int, string]()
+	list.SetMaxLevel(16)
+	list.SetProbability(0.5)
+	for i := 0; i < 20000; i++ {
+		list.Insert(rand.Intn(10000), strconv.Itoa(i))
+	}
+
+	snap := list.Snapshot()
+	list.DeleteRange(0, 999)
+
+	data, err := list.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list2 := NewOrdered[int, string]()
+	list2.Insert(-1, "replaced")
+
+	if err = list2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	checkSpans(t, list2)
+
+	if fmt.Sprint(collect(list.All())) != fmt.Sprint(collect(list2.All())) || list2.Count() != list.Count() {
+		t.Fatal("lists differ after UnmarshalBinary", list.Count(), list2.Count())
+	}
+
+	if list2.maxLevel != 16 || list2.ip != 2 {
+		t.Fatal("max level and probability were not restored", list2.maxLevel, list2.ip)
+	}
+
+	// The list works as usual once it's loaded, including at the levels above the default
+	for i := 0; i < 20000; i++ {
+		list2.Insert(rand.Intn(10000), "")
+	}
+	list2.DeleteRange(5000, 5999)
+	checkSpans(t, list2)
+
+	// The list must be empty of snapshots, and is left unchanged if the data is invalid
+	snap2 := list2.Snapshot()
+	if err = list2.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error loading a list with open snapshots")
+	}
+	snap2.Close()
+	snap.Close()
+
+	count := list2.Count()
+	for _, bad := range [][]byte{nil, data[:len(data)/2], append(data, 0), []byte("SKPL\x02")} {
+		if err = list2.UnmarshalBinary(bad); err == nil {
+			t.Fatal("expected error loading invalid data")
+		}
+	}
+
+	if list2.Count() != count {
+		t.Fatal("list changed by invalid data", count, list2.Count())
+	}
+
+	if err = NewOrdered[string, string]().UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error loading data with different key types")
+	}
+
+	// interface{} keys and values record their types
+	ilist := New(BuiltinLessThan)
+	values := []interface{}{"a", int64(-1), 1.5, []byte("b"), true, uint8(2), float32(3), nil}
+	for i, v := range values {
+		ilist.Insert(i, v)
+	}
+
+	if data, err = ilist.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+
+	ilist2 := New(BuiltinLessThan)
+	if err = ilist2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprintf("%#v", collect(ilist2.All())) != fmt.Sprintf("%#v", collect(ilist.All())) {
+		t.Fatal("lists differ after UnmarshalBinary", collect(ilist2.All()))
+	}
+
+	if err = New(BuiltinGreaterThan).UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error loading data sorted by a different comparator")
+	}
+
+	// Integers that don't fit their type are an error, rather than truncated
+	if _, err = BuiltinCodec[int8]().Decode(binary.AppendVarint(nil, 300)); err == nil {
+		t.Fatal("expected error decoding 300 as an int8")
+	}
+	if _, err = BuiltinCodec[interface{}]().Decode(binary.AppendUvarint([]byte{tagUint16}, 1<<20)); err == nil {
+		t.Fatal("expected error decoding 1<<20 as a uint16")
+	}
+	if v, err := BuiltinCodec[int32]().Decode(binary.AppendVarint(nil, -1<<31)); err != nil || v != -1<<31 {
+		t.Fatal("unexpected int32", v, err)
+	}
+
+	// Values of other types need a codec
+	plist := NewOrdered[int, point]()
+	plist.Insert(1, point{1, 2})
+
+	if _, err = plist.MarshalBinary(); err == nil {
+		t.Fatal("expected error encoding values without a codec")
+	}
+
+	plist.SetCodecs(BuiltinCodec[int](), pointCodec{})
+	if data, err = plist.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+
+	plist2 := NewOrdered[int, point]()
+	plist2.SetCodecs(BuiltinCodec[int](), pointCodec{})
+	if err = plist2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _, _ := plist2.Get(1); v != (point{1, 2}) {
+		t.Fatal("value != {1, 2}", v)
+	}
+
+	// A corrupt length doesn't allocate more than the data that's there
+	slist := NewOrdered[string, string]()
+	slist.Insert("k", "v")
+	data, _ = slist.MarshalBinary()
+	data = append(binary.AppendUvarint(data[:len(data)-4], maxDataLength), 'k')
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err = NewOrdered[string, string]().UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error loading a key longer than the data")
+	}
+	runtime.ReadMemStats(&after)
+
+	if after.TotalAlloc-before.TotalAlloc > 16<<20 {
+		t.Fatal("corrupt length allocated", after.TotalAlloc-before.TotalAlloc)
+	}
+
+	// Closures have generated names, which a stable name can replace
+	byLength := func(a, b string) int { return len(a) - len(b) }
+	flist := NewFunc[string, int](byLength)
+	flist.Insert("abc", 1)
+	data, _ = flist.MarshalBinary()
+
+	flist2 := NewFunc[string, int](func(a, b string) int { return len(a) - len(b) })
+	if err = flist2.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error loading data sorted by a closure with a different name")
+	}
+
+	flist.SetCompareName("byLength")
+	flist2.SetCompareName("byLength")
+	data, _ = flist.MarshalBinary()
+	if err = flist2.UnmarshalBinary(data); err != nil || flist2.Count() != 1 {
+		t.Fatal("error loading data with the same comparator name", err)
+	}
+
+	if flist2.SetCompareName("") == nil {
+		t.Fatal("expected error setting an empty comparator name")
+	}
+}
+
+// collect returns the keys and values of seq, alternating.
+func collect[K, V any](seq func(yield func(K, V) bool)) (kvs []interface{}) {
+	for k, v := range seq {
+		kvs = append(kvs, k, v)
+	}
+	return
+}
+
+// signalWriter closes started on the first write.
+type signalWriter struct {
+	bytes.Buffer
+	started chan struct{}
+}
+
+func (this *signalWriter) Write(p []byte) (int, error) {
+	if this.Len() == 0 {
+		close(this.started)
+	}
+	return this.Buffer.Write(p)
+}
+
+func TestJSON(t *testing.T) {
+	list := NewOrdered[int, string]()
+	list.Insert(2, "b")
+	list.Insert(1, "a")
+	list.Insert(1, "c")
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `[{"key":1,"value":"a"},{"key":1,"value":"c"},{"key":2,"value":"b"}]` {
+		t.Fatal("unexpected JSON", string(data))
+	}
+
+	list2 := NewOrdered[int, string]()
+	if err = json.Unmarshal(data, list2); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(collect(list2.All())) != fmt.Sprint(collect(list.All())) {
+		t.Fatal("lists differ after UnmarshalJSON", collect(list2.All()))
+	}
+
+	for _, bad := range []string{`{}`, `[{"key":1,"value":"a"},5]`, `[{"key":"a","value":"a"}]`} {
+		if err = json.Unmarshal([]byte(bad), list2); err == nil {
+			t.Fatal("expected error decoding", bad)
+		}
+	}
+
+	if list2.Count() != 3 {
+		t.Fatal("list changed by invalid JSON", list2.Count())
+	}
+
+	// Interface lists without a key can't be decoded
+	if err = New(BuiltinLessThan).UnmarshalJSON([]byte(`[{"value":1}]`)); err == nil {
+		t.Fatal("expected error decoding a node without a key")
+	}
+
+	// Changes made while the list is being written are not included
+	big := NewOrdered[int, int]()
+	for i := 0; i < 10000; i++ {
+		big.Insert(i, i)
+	}
+
+	w := &signalWriter{started: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-w.started
+		for i := 0; i < 1000; i++ {
+			big.Insert(rand.Intn(10000), -1)
+			big.Delete(rand.Intn(10000))
+		}
+	}()
+
+	if err = big.EncodeJSON(w); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	var nodes []map[string]int
+	if err = json.Unmarshal(w.Bytes(), &nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, n := range nodes {
+		if n["key"] != i || n["value"] != i {
+			t.Fatal("node", i, "!=", n)
+		}
+	}
+
+	if len(nodes) != 10000 {
+		t.Fatal("number of nodes != 10000", len(nodes))
+	}
+}
+
+func TestWAL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, segmentName(1))
+
+	list := NewOrdered[int, string]()
+	list.SetSyncPolicy(SyncNever, 0)
+	if err := list.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot changes how values are replaced, but not what the list has afterwards
+	var snap *Snapshot[int, string]
+	for i := 0; i < 3000; i++ {
+		k, v := rand.Intn(500), strconv.Itoa(i)
+		switch i % 12 {
+		case 0, 1, 2:
+			list.Insert(k, v)
+		case 3:
+			list.Upsert(k, v)
+		case 4:
+			list.Update(k, func(old string) string { return old + v })
+		case 5:
+			list.InsertIfAbsent(k, v)
+		case 6:
+			list.Delete(k)
+		case 7:
+			list.DeleteBounds(Exclusive(k), Inclusive(k+3))
+		case 8:
+			list.DeleteAt(rand.Intn(list.Count() + 1))
+		case 9:
+			list.PopMin()
+			list.PopMax()
+			list.PopN(2)
+		case 10:
+			batch := list.NewBatch()
+			batch.Insert(k, v)
+			batch.DeleteRange(k+1, k+5)
+			list.Apply(batch)
+		case 11:
+			txn := list.Begin()
+			txn.Insert(k, v)
+			txn.Delete(k + 1)
+			txn.Commit()
+		}
+
+		if i == 1000 {
+			snap = list.Snapshot()
+		}
+	}
+	snap.Close()
+
+	if err := list.UnmarshalBinary(nil); err == nil {
+		t.Fatal("expected error loading into an open list")
+	}
+
+	reopen := func(t *testing.T, unique bool) *Skiplist[int, string] {
+		list := NewOrdered[int, string]()
+		list.SetUnique(unique)
+		if err := list.Open(dir); err != nil {
+			t.Fatal(err)
+		}
+		checkSpans(t, list)
+		return list
+	}
+
+	expected := fmt.Sprint(collect(list.All()))
+	if err := list.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	list = reopen(t, false)
+	if fmt.Sprint(collect(list.All())) != expected {
+		t.Fatal("list differs after replaying the log")
+	}
+
+	// Writes after a replay continue the log
+	list.SetSyncPolicy(SyncAlways, 0)
+	list.Insert(1000, "a")
+	list.Close()
+
+	info, _ := os.Stat(path)
+	size := info.Size()
+
+	list = reopen(t, false)
+	expected = fmt.Sprint(collect(list.All()))
+	list.Insert(1001, "b")
+	list.Close()
+
+	if list.Open(dir) == nil {
+		t.Fatal("expected error opening a list that isn't empty")
+	}
+
+	// A torn record or zeros at the end are cut off, leaving the writes before them
+	data, _ := os.ReadFile(path)
+	for _, tail := range [][]byte{data[:len(data)-3], data[:size+5], append(data[:size:size], make([]byte, 20)...)} {
+		os.WriteFile(path, tail, 0644)
+
+		list = reopen(t, false)
+		if fmt.Sprint(collect(list.All())) != expected {
+			t.Fatal("list differs after cutting off a torn record")
+		}
+		list.Close()
+
+		if info, _ = os.Stat(path); info.Size() != size {
+			t.Fatal("torn record was not cut off", info.Size(), size)
+		}
+	}
+
+	// A bad record before the end is an error, and the log is left as it is
+	data[walHeaderSize+1] ^= 1
+	os.WriteFile(path, data, 0644)
+
+	list = NewOrdered[int, string]()
+	if list.Open(dir) == nil {
+		t.Fatal("expected error opening a corrupt log")
+	}
+
+	if list.Count() != 0 {
+		t.Fatal("list not empty after a failed Open", list.Count())
+	}
+
+	if info, _ = os.Stat(path); info.Size() != int64(len(data)) {
+		t.Fatal("corrupt log was changed", info.Size(), len(data))
+	}
+
+	// Batches that were rolled back aren't logged
+	dir = t.TempDir()
+	list = NewOrdered[int, string]()
+	list.SetUnique(true)
+	list.SetSyncPolicy(SyncInterval, time.Millisecond)
+	list.Open(dir)
+
+	batch := list.NewBatch()
+	batch.Insert(1, "a")
+	batch.Insert(2, "b")
+	list.Apply(batch)
+	if err := list.Apply(batch); err != ErrDuplicateKey {
+		t.Fatal("expected ErrDuplicateKey", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	list.Close()
+
+	if list = reopen(t, true); fmt.Sprint(collect(list.All())) != "[1 a 2 b]" {
+		t.Fatal("unexpected list after replaying a rolled back batch", collect(list.All()))
+	}
+	list.Close()
+
+	// Deletes that can't be logged return the error, and don't delete anything
+	list = reopen(t, true)
+	list.wal.file.Close()
+	if _, _, ok, err := list.PopMin(); ok || err == nil {
+		t.Fatal("expected error popping from a list with a closed log")
+	}
+	if _, _, ok, err := list.PopMax(); ok || err == nil {
+		t.Fatal("expected error popping from a list with a closed log")
+	}
+	if _, err := list.PopN(2); err == nil {
+		t.Fatal("expected error popping from a list with a closed log")
+	}
+	if _, _, ok, err := list.DeleteAt(0); ok || err == nil {
+		t.Fatal("expected error deleting from a list with a closed log")
+	}
+	if list.Count() != 2 {
+		t.Fatal("nodes deleted without being logged", list.Count())
+	}
+	list.Close()
+
+	if list.SetSyncPolicy(SyncInterval, 0) == nil || list.SetSyncPolicy(SyncNever+1, 0) == nil {
+		t.Fatal("expected error setting an invalid sync policy")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	list := NewOrdered[int, string]()
+	if list.Checkpoint() == nil {
+		t.Fatal("expected error checkpointing a list that isn't open")
+	}
+
+	list.SetSyncPolicy(SyncNever, 0)
+	if err := list.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	segments := func() (names []string) {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if isSegment(e.Name()) {
+				names = append(names, e.Name())
+			}
+		}
+		return
+	}
+
+	reopen := func(t *testing.T) *Skiplist[int, string] {
+		list := NewOrdered[int, string]()
+		list.SetSyncPolicy(SyncNever, 0)
+		if err := list.Open(dir); err != nil {
+			t.Fatal(err)
+		}
+		checkSpans(t, list)
+		return list
+	}
+
+	for i := 0; i < 2000; i++ {
+		list.Insert(rand.Intn(1000), strconv.Itoa(i))
+	}
+
+	// Writes made while the checkpoint is written go to the next segment
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			list.Upsert(rand.Intn(1000), "concurrent")
+			list.Delete(rand.Intn(1000))
+		}
+	}()
+
+	if err := list.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if s := segments(); len(s) != 1 || s[0] == segmentName(1) {
+		t.Fatal("log segments in the checkpoint were not removed", s)
+	}
+
+	list.Insert(-1, "after")
+	expected := fmt.Sprint(collect(list.All()))
+	list.Close()
+
+	list = reopen(t)
+	if fmt.Sprint(collect(list.All())) != expected {
+		t.Fatal("list differs after loading the checkpoint")
+	}
+
+	// A crash after writing a checkpoint can leave the segments it includes, whose records are
+	// skipped, and a crash while writing one leaves a temporary file, which is ignored
+	old := map[string][]byte{}
+	for _, name := range segments() {
+		old[name], _ = os.ReadFile(filepath.Join(dir, name))
+	}
+
+	list.Insert(-2, "before checkpoint")
+	if err := list.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	list.Insert(-3, "after checkpoint")
+	expected = fmt.Sprint(collect(list.All()))
+	list.Close()
+
+	for name, data := range old {
+		os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	os.WriteFile(filepath.Join(dir, checkpointTemp), []byte("partial"), 0644)
+
+	list = reopen(t)
+	if fmt.Sprint(collect(list.All())) != expected {
+		t.Fatal("list differs after replaying segments in the checkpoint")
+	}
+	list.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, checkpointTemp)); !os.IsNotExist(err) {
+		t.Fatal("temporary checkpoint was not removed", err)
+	}
+
+	// Checkpoints without writes in between don't start empty segments
+	list = reopen(t)
+	list.Checkpoint()
+	list.Checkpoint()
+	if s := segments(); len(s) != 1 {
+		t.Fatal("expected one log segment", s)
+	}
+	list.Close()
+
+	// Missing records after the checkpoint, or a corrupt checkpoint, are errors
+	for _, name := range segments() {
+		os.Remove(filepath.Join(dir, name))
+	}
+	file, _ := createSegment(dir, 1000000)
+	w := &wal{file: file, lsn: 999999, policy: SyncNever}
+	w.append(binary.AppendUvarint(binary.AppendUvarint(w.begin(walDeleteAt), 0), 1))
+	file.Close()
+
+	if NewOrdered[int, string]().Open(dir) == nil {
+		t.Fatal("expected error opening a log with missing records")
+	}
+	os.Remove(filepath.Join(dir, segmentName(1000000)))
+
+	data, _ := os.ReadFile(filepath.Join(dir, checkpointFile))
+	data[len(data)/2] ^= 1
+	os.WriteFile(filepath.Join(dir, checkpointFile), data, 0644)
+
+	if NewOrdered[int, string]().Open(dir) == nil {
+		t.Fatal("expected error opening a corrupt checkpoint")
+	}
+
+	// The current segment is synced before the next one is started, so a segment that can't be
+	// synced isn't followed by another one
+	dir = t.TempDir()
+	list = NewOrdered[int, string]()
+	list.SetSyncPolicy(SyncNever, 0)
+	list.Open(dir)
+	list.Insert(1, "a")
+
+	list.wal.file.Close()
+	if list.Checkpoint() == nil {
+		t.Fatal("expected error checkpointing a log that can't be synced")
+	}
+
+	if s := segments(); len(s) != 1 {
+		t.Fatal("segment started after a failed sync", s)
+	}
+
+	// Only the last segment can have a torn record, in an earlier one it's corruption
+	dir = t.TempDir()
+	list = NewOrdered[int, string]()
+	list.SetSyncPolicy(SyncNever, 0)
+	list.Open(dir)
+	list.Insert(1, "a")
+	list.Insert(2, "b")
+	if err := list.wal.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	list.Insert(3, "c")
+	list.Close()
+
+	first := filepath.Join(dir, segmentName(1))
+	data, _ = os.ReadFile(first)
+	os.WriteFile(first, data[:len(data)-3], 0644)
+
+	if NewOrdered[int, string]().Open(dir) == nil {
+		t.Fatal("expected error opening a log with a torn record in an earlier segment")
+	}
+
+	if info, _ := os.Stat(first); info.Size() != int64(len(data)-3) {
+		t.Fatal("earlier segment was cut off", info.Size(), len(data)-3)
+	}
+}
+
+func TestMapped(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.skm")
+
+	list := NewOrdered[string, int]()
+	for i := 0; i < 5000; i++ {
+		list.Insert(strconv.Itoa(rand.Intn(1000)), i)
+	}
+
+	// Tombstones kept for snapshots are left out
+	snap := list.Snapshot()
+	list.DeleteRange("2", "3")
+	if err := list.WriteMapped(path); err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+
+	m := NewMappedOrdered[string, int]()
+	if err := m.Open(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Count() != list.Count() {
+		t.Fatal("unexpected count", m.Count(), list.Count())
+	}
+
+	mapped := func(iter *MappedIterator[string, int], err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var s []string
+		for iter.Next() {
+			if string(iter.KeyBytes()) != iter.Key() {
+				t.Fatal("KeyBytes differs from Key", iter.KeyBytes(), iter.Key())
+			}
+			s = append(s, fmt.Sprint(iter.Key(), iter.Value()))
+		}
+
+		if iter.Err() != nil {
+			t.Fatal(iter.Err())
+		}
+		return strings.Join(s, " ")
+	}
+
+	expected := func(iter *Iterator[string, int], err error) string {
+		var s []string
+		for iter.Next() {
+			s = append(s, fmt.Sprint(iter.Key(), iter.Value()))
+		}
+		return strings.Join(s, " ")
+	}
+
+	bounds := func(key string) []Bound[string] {
+		return []Bound[string]{Inclusive(key), Exclusive(key), Unbounded[string]()}
+	}
+
+	for i := 0; i < 50; i++ {
+		k1, k2 := strconv.Itoa(rand.Intn(1100)), strconv.Itoa(rand.Intn(1100))
+
+		if got, exp := mapped(m.Select(k1)), expected(list.Select(k1)); got != exp {
+			t.Fatalf("Select(%s) = %s, expected %s", k1, got, exp)
+		}
+
+		if got, exp := mapped(m.SelectRange(k1, k2)), expected(list.SelectRange(k1, k2)); got != exp {
+			t.Fatalf("SelectRange(%s, %s) = %s, expected %s", k1, k2, got, exp)
+		}
+
+		for _, lo := range bounds(k1) {
+			for _, hi := range bounds(k2) {
+				if got, exp := mapped(m.SelectBounds(lo, hi)), expected(list.SelectBounds(lo, hi)); got != exp {
+					t.Fatalf("SelectBounds(%v, %v) = %s, expected %s", lo, hi, got, exp)
+				}
+			}
+		}
+
+		v, ok, err := m.Get(k1)
+		if lv, lok, _ := list.Get(k1); err != nil || ok != lok || v != lv {
+			t.Fatalf("Get(%s) = %d, %v, %v, expected %d, %v", k1, v, ok, err, lv, lok)
+		}
+	}
+
+	// Replacing the file doesn't affect the open mapping
+	iter, _ := m.SelectRange("0", "1")
+	count := list.Count()
+	list.DeleteRange("0", "1")
+	list.WriteMapped(path)
+
+	n := 0
+	for iter.Next() {
+		n++
+	}
+
+	if iter.Err() != nil || n == 0 || n != count-list.Count() {
+		t.Fatal("replacing the file changed the mapping", iter.Err(), n)
+	}
+
+	// Iterators stop once the file is closed
+	iter, _ = m.SelectBounds(Unbounded[string](), Unbounded[string]())
+	iter.Next()
+	m.Close()
+	if k, v := iter.Key(), iter.Value(); k != "" || v != 0 || iter.Err() == nil {
+		t.Fatal("expected error decoding from a closed file", k, v)
+	}
+	if iter.Next() || iter.Err() == nil {
+		t.Fatal("expected error iterating over a closed file")
+	}
+
+	if _, err := m.Select("1"); err == nil {
+		t.Fatal("expected error selecting from a closed file")
+	}
+
+	// Keys that are decoded to be compared, and interface{} lists
+	ints := NewOrdered[int, string]()
+	dynamic := New(BuiltinCompare)
+	for i := 0; i < 1000; i++ {
+		k := rand.Intn(500)
+		ints.Insert(k, strconv.Itoa(i))
+		dynamic.Insert(k, strconv.Itoa(i))
+	}
+	ints.WriteMapped(filepath.Join(dir, "ints.skm"))
+	dynamic.WriteMapped(filepath.Join(dir, "dynamic.skm"))
+
+	mints := NewMappedOrdered[int, string]()
+	if err := mints.Open(filepath.Join(dir, "ints.skm")); err != nil {
+		t.Fatal(err)
+	}
+	defer mints.Close()
+
+	mdynamic := NewMapped(BuiltinCompare)
+	if err := mdynamic.Open(filepath.Join(dir, "dynamic.skm")); err != nil {
+		t.Fatal(err)
+	}
+	defer mdynamic.Close()
+
+	for i := 0; i < 100; i++ {
+		k1 := rand.Intn(500)
+		k2 := k1 + rand.Intn(50)
+
+		var got, got2, exp []string
+		iter, _ := mints.SelectRange(k1, k2)
+		for iter.Next() {
+			got = append(got, fmt.Sprint(iter.Key(), iter.Value()))
+		}
+
+		iter2, _ := mdynamic.SelectRange(k1, k2)
+		for iter2.Next() {
+			got2 = append(got2, fmt.Sprint(iter2.Key(), iter2.Value()))
+		}
+
+		liter, _ := ints.SelectRange(k1, k2)
+		for liter.Next() {
+			exp = append(exp, fmt.Sprint(liter.Key(), liter.Value()))
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(exp) || fmt.Sprint(got2) != fmt.Sprint(exp) {
+			t.Fatalf("SelectRange(%d, %d) = %v and %v, expected %v", k1, k2, got, got2, exp)
+		}
+	}
+
+	if mdynamic.Open(filepath.Join(dir, "dynamic.skm")) == nil {
+		t.Fatal("expected error opening an open list")
+	}
+
+	if NewMappedOrdered[int, int]().Open(filepath.Join(dir, "ints.skm")) == nil {
+		t.Fatal("expected error opening a file with different value types")
+	}
+
+	// Corrupt files are rejected by Open, or stop the iterators, but never crash them
+	data, _ := os.ReadFile(filepath.Join(dir, "ints.skm"))
+	bad := filepath.Join(dir, "bad.skm")
+	for i := 0; i < 200; i++ {
+		corrupt := append([]byte(nil), data...)
+		if i%2 == 0 {
+			corrupt = corrupt[:rand.Intn(len(corrupt))]
+		} else {
+			for j := 0; j < 10; j++ {
+				corrupt[rand.Intn(len(corrupt))] = byte(rand.Intn(256))
+			}
+		}
+		os.WriteFile(bad, corrupt, 0644)
+
+		m := NewMappedOrdered[int, string]()
+		if m.Open(bad) != nil {
+			continue
+		}
+
+		iter, err := m.SelectBounds(Unbounded[int](), Unbounded[int]())
+		for err == nil && iter.Next() {
+			iter.Key()
+			iter.Value()
+		}
+		m.Get(rand.Intn(500))
+		m.Close()
+	}
+}
+
+func TestArena(t *testing.T) {
+	list := NewOrdered[int, string]()
+	list.SetArena(16)
+	list.SetProbability(0.5)
+	expected := NewOrdered[int, string]()
+
+	for i := 0; i < 5000; i++ {
+		k := rand.Intn(1000)
+		if rand.Intn(4) == 0 {
+			list.DeleteRange(k, k+10)
+			expected.DeleteRange(k, k+10)
+		} else {
+			list.Insert(k, strconv.Itoa(i))
+			expected.Insert(k, strconv.Itoa(i))
+		}
+	}
+	checkSpans(t, list)
+
+	if fmt.Sprint(collect(list.All())) != fmt.Sprint(collect(expected.All())) {
+		t.Fatal("list with an arena differs")
+	}
+
+	// Nodes with more levels than a chunk has room for get their own arrays
+	list.SetMaxLevel(64)
+	list.SetProbability(1)
+	for i := 0; i < 10; i++ {
+		list.Insert(rand.Intn(1000), "tall")
+	}
+	checkSpans(t, list)
+
+	// Lists loaded from binary data use the arena too
+	data, _ := expected.MarshalBinary()
+	list.SetProbability(0.25)
+	if err := list.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	checkSpans(t, list)
+
+	if fmt.Sprint(collect(list.All())) != fmt.Sprint(collect(expected.All())) {
+		t.Fatal("list loaded with an arena differs")
+	}
+
+	// Deleted nodes are zeroed once they're unlinked, so their chunk doesn't keep their values,
+	// but the deletes and the iterators that were on them still return their keys and values
+	rIter := list.Iterate()
+	rIter.First()
+	p, first, value := list.headNode.next[0], rIter.Key(), rIter.Value()
+
+	dIter, _ := list.Delete(first)
+	if p.key != 0 || p.value != "" {
+		t.Fatal("deleted node was not zeroed", p.key, p.value)
+	}
+
+	if !dIter.Next() || dIter.Key() != first || dIter.Value() != value {
+		t.Fatal("deleted node != ", first, value, dIter.Key(), dIter.Value())
+	}
+
+	if rIter.Key() != first || rIter.Value() != value {
+		t.Fatal("iterator on the deleted node != ", first, value, rIter.Key(), rIter.Value())
+	}
+
+	min, minValue, _ := list.Min()
+	if !rIter.Next() || rIter.Key() != min {
+		t.Fatal("iterator didn't move past the deleted node", rIter.Key(), min)
+	}
+
+	if k, v, _, _ := list.PopMin(); k != min || v != minValue {
+		t.Fatal("PopMin != Min", k, v, min, minValue)
+	}
+
+	// Rolling back a batch restores the keys and values of the nodes it unlinked
+	ulist := NewOrdered[int, string]()
+	ulist.SetUnique(true)
+	ulist.SetArena(16)
+	for i := 0; i < 100; i++ {
+		ulist.Insert(i, strconv.Itoa(i))
+	}
+
+	batch := ulist.NewBatch()
+	batch.DeleteRange(10, 20)
+	batch.Insert(50, "dup")
+	if err := ulist.Apply(batch); err != ErrDuplicateKey {
+		t.Fatal("expected ErrDuplicateKey, got", err)
+	}
+
+	for k, v := range ulist.All() {
+		if v != strconv.Itoa(k) {
+			t.Fatal("value of", k, "!=", k, v)
+		}
+	}
+
+	if list.SetArena(-1) == nil || list.SetArena(maxArenaChunk+1) == nil {
+		t.Fatal("expected error setting a chunk size out of range")
+	}
+
+	list.SetArena(1024)
+	k := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		k++
+		list.Insert(k, "")
+	})
+
+	if allocs >= 0.5 {
+		t.Fatal("expected inserts to allocate less than one object each", allocs)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	list := NewBytes[[]byte]()
+	list.SetArena(64)
+	if err := list.SetInlineBytes(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys and values are copied into the arena, so the buffers they came from can be reused
+	key, value := make([]byte, 8), make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		binary.BigEndian.PutUint64(key, uint64(rand.Intn(500)))
+		binary.BigEndian.PutUint64(value, uint64(i))
+		if _, err := list.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prev := []byte(nil)
+	for k, v := range list.All() {
+		if len(k) != 8 || len(v) != 8 || bytes.Compare(prev, k) >= 0 {
+			t.Fatal("unexpected key", k, prev)
+		}
+		prev = k
+	}
+
+	// Upserts keep the latest value
+	for i := 0; i < 1000; i++ {
+		binary.BigEndian.PutUint64(key, uint64(rand.Intn(500)))
+		binary.BigEndian.PutUint64(value, uint64(i))
+		list.Upsert(key, value)
+
+		if v, ok, _ := list.Get(key); !ok || !bytes.Equal(v, value) {
+			t.Fatal("unexpected value", v, value)
+		}
+	}
+	checkSpans(t, list)
+
+	if allocs := testing.AllocsPerRun(1000, func() {
+		binary.BigEndian.PutUint64(key, uint64(rand.Int63()))
+		list.Insert(key, value)
+	}); allocs >= 0.5 {
+		t.Fatal("expected inserts to allocate less than one object each", allocs)
+	}
+
+	// Mapped lists compare the keys in place
+	path := filepath.Join(t.TempDir(), "bytes.skm")
+	list.WriteMapped(path)
+
+	m := NewMappedBytes[[]byte]()
+	if err := m.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := 0; i < 100; i++ {
+		binary.BigEndian.PutUint64(key, uint64(rand.Intn(500)))
+		v, ok, err := m.Get(key)
+		if lv, lok, _ := list.Get(key); err != nil || ok != lok || !bytes.Equal(v, lv) {
+			t.Fatal("unexpected value", v, lv, ok, lok, err)
+		}
+	}
+
+	if NewBytes[int]().SetInlineBytes(true) == nil {
+		t.Fatal("expected error storing bytes inline without an arena")
+	}
+
+	// Empty slices stay empty rather than nil
+	elist := NewBytes[[]byte]()
+	elist.SetArena(4)
+	elist.SetInlineBytes(true)
+	elist.Insert([]byte{}, []byte{})
+
+	if k, v, _ := elist.Min(); k == nil || v == nil {
+		t.Fatal("empty slice became nil", k, v)
+	}
+}
+
+func TestLockFreeSameAsSkiplist(t *testing.T) {
+	list := NewOrdered[int, int]()
+	lf := NewLockFreeOrdered[int, int]()
+
+	for i := 0; i < 10000; i++ {
+		k := rand.Intn(1000)
+		list.Insert(k, i)
+		if _, err := lf.Insert(k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		k1 := rand.Intn(1000)
+		k2 := k1 + rand.Intn(50)
+
+		rIter, _ := list.SelectRange(k1, k2)
+		lIter, _ := lf.SelectRange(k1, k2)
+
+		if rIter.Count() != lIter.Count() {
+			t.Fatal("number of results for", k1, k2, "!=", rIter.Count(), lIter.Count())
+		}
+
+		for rIter.Next() && lIter.Next() {
+			if rIter.Key() != lIter.Key() || rIter.Value() != lIter.Value() {
+				t.Fatal("nodes differ", rIter.Key(), rIter.Value(), lIter.Key(), lIter.Value())
+			}
+		}
+
+		if i%10 == 0 {
+			rIter, _ = list.DeleteRange(k1, k2)
+			lIter, _ = lf.DeleteRange(k1, k2)
+			if rIter.Count() != lIter.Count() || list.Count() != lf.Count() {
+				t.Fatal("number of deleted nodes !=", rIter.Count(), lIter.Count())
+			}
+		}
+	}
+
+	if _, err := NewLockFree(BuiltinLessThan).SelectRange(1, "a"); err == nil {
+		t.Fatal("expected error selecting a range of different types")
+	}
+
+	// The nodes Insert returns can be used the same way with either list, even though LockFree
+	// returns a copy that isn't linked to anything
+	for _, l := range []List[int, int]{NewOrdered[int, int](), NewLockFreeOrdered[int, int]()} {
+		n, err := l.Insert(1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n.GetKey() != 1 || n.GetValue() != 2 || n.Next() != nil || n.NextAtLevel(0) != nil {
+			t.Fatal("unexpected node", n.GetKey(), n.GetValue(), n.Next())
+		}
+		n.Prev()
+	}
+
+	// So can the copies an arena list makes of the nodes it deletes
+	alist := NewOrdered[int, int]()
+	alist.SetArena(16)
+	alist.Insert(1, 1)
+	alist.Insert(2, 2)
+
+	if pIter, _ := alist.PopN(1); pIter.buf[0].Next() != nil || pIter.buf[0].Prev() != nil {
+		t.Fatal("popped node is linked")
+	}
+}
+
+// Each goroutine works on its own keys, so every operation has to see the effect of the
+// operations the same goroutine completed before it, whatever the other goroutines are doing.
+func TestLockFreeConcurrent(t *testing.T) {
+	lf := NewLockFreeOrdered[int, int]()
+	goroutines, rounds := 8, 2000
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*2)
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(2)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < rounds; i++ {
+				k := i*goroutines + g
+				lf.Insert(k, i)
+				lf.Insert(k, i+1)
+
+				if rIter, _ := lf.Select(k); rIter.Count() != 2 || !rIter.Next() || rIter.Value() != i {
+					errs <- fmt.Errorf("key %d: expected 2 nodes, oldest first, got %d", k, rIter.Count())
+					return
+				}
+
+				if i%2 == 0 {
+					if dIter, _ := lf.Delete(k); dIter.Count() != 2 {
+						errs <- fmt.Errorf("key %d: deleted %d nodes instead of 2", k, dIter.Count())
+						return
+					}
+
+					if rIter, _ := lf.Select(k); rIter.Count() != 0 {
+						errs <- fmt.Errorf("key %d: still found after delete", k)
+						return
+					}
+				} else {
+					// Keep one of the two nodes for the final count
+					if dIter, _ := lf.DeleteRange(k, k); dIter.Count() != 2 {
+						errs <- fmt.Errorf("key %d: deleted %d nodes instead of 2", k, dIter.Count())
+						return
+					}
+					lf.Insert(k, i)
+				}
+			}
+		}(g)
+
+		// Concurrent readers must always see sorted ranges
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < rounds/10; i++ {
+				k := rand.Intn(rounds * goroutines)
+				rIter, _ := lf.SelectRange(k, k+500)
+				for prev := -1; rIter.Next(); prev = rIter.Key() {
+					if rIter.Key() < prev || rIter.Key() < k || rIter.Key() > k+500 {
+						errs <- fmt.Errorf("range [%d, %d] out of order: %d after %d", k, k+500, rIter.Key(), prev)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for p := lf.nextLive(lf.headNode); p != nil; p = lf.nextLive(p) {
+		n++
+	}
+
+	if n != lf.Count() || n != goroutines*rounds/2 {
+		t.Fatal("count !=", goroutines*rounds/2, n, lf.Count())
+	}
+}
+
+func TestLockFreeConcurrentDeleteRange(t *testing.T) {
+	lf := NewLockFreeOrdered[int, int]()
+	for i := 0; i < 10000; i++ {
+		lf.Insert(rand.Intn(1000), i)
+	}
+
+	var wg sync.WaitGroup
+	deleted := make([]int, 4)
+
+	for g := range deleted {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			dIter, _ := lf.DeleteRange(0, 999)
+			deleted[g] = dIter.Count()
+		}(g)
+	}
+	wg.Wait()
+
+	if deleted[0]+deleted[1]+deleted[2]+deleted[3] != 10000 || lf.Count() != 0 {
+		t.Fatal("nodes deleted more than once, or not at all", deleted, lf.Count())
+	}
+
+	for l := 0; l < lf.maxLevel; l++ {
+		if lf.headNode.next[l].Load().node != nil {
+			t.Fatal("deleted nodes still linked at level", l)
+		}
+	}
+}
+
+// Goroutines select and delete the same keys while the owner of each key inserts it again, and
+// the history of each key is checked for linearizability. A key has at most one node, since only
+// its owner inserts it, once it has seen that it's not in the list.
+func TestLockFreeLinearizable(t *testing.T) {
+	// The checker itself
+	if linearizable([]lfOp{{kind: lfSelect, result: 1, call: 1, ret: 2}}) ||
+		linearizable([]lfOp{{kind: lfInsert, call: 1, ret: 2}, {kind: lfSelect, result: 0, call: 3, ret: 4}}) {
+		t.Fatal("history that is not linearizable was accepted")
+	}
+	if !linearizable([]lfOp{{kind: lfInsert, call: 1, ret: 4}, {kind: lfSelect, result: 1, call: 2, ret: 3},
+		{kind: lfDelete, result: 1, call: 5, ret: 8}, {kind: lfDelete, result: 0, call: 6, ret: 7}}) {
+		t.Fatal("linearizable history was rejected")
+	}
+
+	lf := NewLockFreeOrdered[int, int]()
+	goroutines, keys, visits := 4, 32, 8
+
+	var clock atomic.Int64
+	histories := make([][][]lfOp, goroutines)
+
+	var wg sync.WaitGroup
+	for g := range histories {
+		histories[g] = make([][]lfOp, keys)
+
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(g)))
+
+			for i := 0; i < keys*visits; i++ {
+				k := i % keys
+				owner := k%goroutines == g
+
+				op := lfOp{kind: lfSelect, call: clock.Add(1)}
+				if !owner && r.Intn(2) == 0 {
+					op.kind = lfDelete
+					dIter, _ := lf.Delete(k)
+					op.result = dIter.Count()
+				} else {
+					rIter, _ := lf.Select(k)
+					op.result = rIter.Count()
+				}
+				op.ret = clock.Add(1)
+				histories[g][k] = append(histories[g][k], op)
+
+				if owner && op.result == 0 {
+					op = lfOp{kind: lfInsert, call: clock.Add(1)}
+					lf.Insert(k, i)
+					op.ret = clock.Add(1)
+					histories[g][k] = append(histories[g][k], op)
+				}
+
+				// Interleave the goroutines even on a single CPU
+				runtime.Gosched()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for k := 0; k < keys; k++ {
+		var ops []lfOp
+		for g := range histories {
+			ops = append(ops, histories[g][k]...)
+		}
+
+		if !linearizable(ops) {
+			t.Fatal("history of key", k, "is not linearizable", ops)
+		}
+	}
+}
+
+// lfOp is an operation on one key of a LockFree list, with the clock ticks at its call and return.
+type lfOp struct {
+	kind      int
+	result    int
+	call, ret int64
+}
+
+const (
+	lfInsert = iota
+	lfSelect
+	lfDelete
+)
+
+// linearizable returns true if ops, up to 64 operations on the same key, can be put in an order
+// where each one takes effect between its call and its return, and returns the number of nodes
+// the key has at that point. It's Wing and Gong's search, remembering the states that failed.
+func linearizable(ops []lfOp) bool {
+	all := uint64(1)<<len(ops) - 1
+	failed := map[[2]uint64]bool{}
+
+	var search func(done uint64, count int) bool
+	search = func(done uint64, count int) bool {
+		if done == all {
+			return true
+		} else if failed[[2]uint64{done, uint64(count)}] {
+			return false
+		}
+
+		// Only an operation called before every pending operation returned can go next
+		first := int64(math.MaxInt64)
+		for i, op := range ops {
+			if done&(1<<i) == 0 {
+				first = min(first, op.ret)
+			}
+		}
+
+		for i, op := range ops {
+			if done&(1<<i) != 0 || op.call > first {
+				continue
+			}
+
+			next := count
+			switch op.kind {
+			case lfInsert:
+				next++
+			case lfSelect:
+				if op.result != count {
+					continue
+				}
+			case lfDelete:
+				if op.result != count {
+					continue
+				}
+				next = 0
+			}
+
+			if search(done|1<<i, next) {
+				return true
+			}
+		}
+
+		failed[[2]uint64{done, uint64(count)}] = true
+		return false
+	}
+
+	return search(0, 0)
+}
+
 func BenchmarkInsertTimeDescending(b *testing.B) {
 	list := New(BuiltinGreaterThan)
 	keys := make([]int64, b.N)
@@ -471,3 +3216,187 @@ func BenchmarkInsertString(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkInsertOrderedInt64(b *testing.B) {
+	list := NewOrdered[int64, int]()
+	keys := make([]int64, b.N)
+	for i := 0; i < b.N; i++ {
+		keys[i] = int64(rand.Intn(b.N))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := list.Insert(keys[i], i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkInsertArena(b *testing.B, chunk int) {
+	list := NewOrdered[int64, int]()
+	list.SetArena(chunk)
+	keys := make([]int64, b.N)
+	for i := 0; i < b.N; i++ {
+		keys[i] = int64(rand.Intn(b.N))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := list.Insert(keys[i], i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Compare with BenchmarkInsertArena, which allocates the nodes in chunks
+func BenchmarkInsertNoArena(b *testing.B) {
+	benchmarkInsertArena(b, 0)
+}
+
+func BenchmarkInsertArena(b *testing.B) {
+	benchmarkInsertArena(b, 4096)
+}
+
+// benchmarkGCArena measures a full garbage collection with a list of a million nodes in the
+// heap, and reports the number of heap objects and the stop-the-world pause of each collection.
+func benchmarkGCArena(b *testing.B, chunk int) {
+	list := NewOrdered[int64, int]()
+	list.SetArena(chunk)
+	for i := 0; i < 1<<20; i++ {
+		list.Insert(rand.Int63(), i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapObjects), "objects")
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+	runtime.KeepAlive(list)
+}
+
+// Compare with BenchmarkGCArena, which allocates the nodes in chunks
+func BenchmarkGCNoArena(b *testing.B) {
+	benchmarkGCArena(b, 0)
+}
+
+func BenchmarkGCArena(b *testing.B) {
+	benchmarkGCArena(b, 4096)
+}
+
+func benchmarkInsertBytes(b *testing.B, inline bool) {
+	list := NewBytes[[]byte]()
+	list.SetArena(4096)
+	list.SetInlineBytes(inline)
+
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(rand.Intn(b.N)))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// Without inline bytes, the list has to be given its own copy of the key and value
+	for i := 0; i < b.N; i++ {
+		key, value := keys[i], keys[i]
+		if !inline {
+			key, value = bytes.Clone(key), bytes.Clone(value)
+		}
+
+		if _, err := list.Insert(key, value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Compare with BenchmarkInsertBytesInline, which copies the keys and values into the arena
+func BenchmarkInsertBytes(b *testing.B) {
+	benchmarkInsertBytes(b, false)
+}
+
+func BenchmarkInsertBytesInline(b *testing.B) {
+	benchmarkInsertBytes(b, true)
+}
+
+func BenchmarkInsertParallelMutex(b *testing.B) {
+	list := NewOrdered[int, int]()
+
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			list.Insert(r.Int(), 0)
+		}
+	})
+}
+
+func BenchmarkInsertParallelLockFree(b *testing.B) {
+	lf := NewLockFreeOrdered[int, int]()
+
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			lf.Insert(r.Int(), 0)
+		}
+	})
+}
+
+func BenchmarkMixedParallelMutex(b *testing.B) {
+	list := NewOrdered[int, int]()
+	for i := 0; i < 100000; i++ {
+		list.Insert(rand.Intn(1000000), i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			k := r.Intn(1000000)
+			switch r.Intn(4) {
+			case 0:
+				list.Insert(k, 0)
+			case 1:
+				list.Delete(k)
+			default:
+				rIter, _ := list.Select(k)
+				for rIter.Next() {
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkMixedParallelLockFree(b *testing.B) {
+	lf := NewLockFreeOrdered[int, int]()
+	for i := 0; i < 100000; i++ {
+		lf.Insert(rand.Intn(1000000), i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			k := r.Intn(1000000)
+			switch r.Intn(4) {
+			case 0:
+				lf.Insert(k, 0)
+			case 1:
+				lf.Delete(k)
+			default:
+				rIter, _ := lf.Select(k)
+				for rIter.Next() {
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only view of the list as it was when the snapshot was taken. Nodes inserted
+// after that are not seen, and nodes deleted after that, or replaced by Upsert or Update, are
+// still seen. The list keeps deleted nodes as tombstones while a snapshot can see them, so
+// snapshots should be closed once they are not needed anymore.
+//
+// Iterators returned by a snapshot are lazy, as with Skiplist, but their results don't change
+// while the list does. They must not be used after the snapshot is closed.
+type Snapshot[K, V any] struct {
+	list *Skiplist[K, V]
+
+	// Sequence number of the list when the snapshot was taken
+	seq uint64
+
+	closed atomic.Bool
+}
+
+// Snapshot returns a snapshot of the current contents of the list.
+func (this *Skiplist[K, V]) Snapshot() *Snapshot[K, V] {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.snapshot()
+}
+
+// snapshot returns a snapshot of the list. The caller must hold the write lock.
+func (this *Skiplist[K, V]) snapshot() *Snapshot[K, V] {
+	// Sequence numbers only increase, so the snapshots stay sorted
+	this.snapshots = append(this.snapshots, this.seq)
+
+	return &Snapshot[K, V]{
+		list: this,
+		seq:  this.seq,
+	}
+}
+
+// Seq returns the sequence number of the list the snapshot was taken at.
+func (this *Snapshot[K, V]) Seq() uint64 {
+	return this.seq
+}
+
+func (this *Snapshot[K, V]) Select(key K) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("Select", Inclusive(key), Inclusive(key))
+}
+
+func (this *Snapshot[K, V]) SelectRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("SelectRange", Inclusive(key1), Inclusive(key2))
+}
+
+func (this *Snapshot[K, V]) SelectBounds(lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("SelectBounds", lo, hi)
+}
+
+func (this *Snapshot[K, V]) selectBounds(name string, lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	if this.closed.Load() {
+		return nil, errors.New("skiplist/Snapshot." + name + ": snapshot is closed")
+	}
+
+	return this.list.selectBounds("Snapshot."+name, lo, hi, nil, this.seq)
+}
+
+// Count returns the number of nodes in the snapshot. It walks the whole list, so it takes O(n)
+// time.
+func (this *Snapshot[K, V]) Count() int {
+	if this.closed.Load() {
+		return 0
+	}
+
+	count, _ := this.list.countRange(Unbounded[K](), Unbounded[K](), this.seq)
+	return count
+}
+
+// Close releases the snapshot. Tombstones that no other snapshot can see are removed from the
+// list.
+func (this *Snapshot[K, V]) Close() (err error) {
+	if this.closed.Swap(true) {
+		return errors.New("skiplist/Snapshot.Close: snapshot is already closed")
+	}
+
+	this.list.mutex.Lock()
+	defer this.list.mutex.Unlock()
+
+	return this.list.release(this.seq)
+}
+
+// release removes the snapshot taken at seq from the open snapshots, and reclaims the tombstones
+// no other snapshot can see. The caller must hold the write lock.
+func (this *Skiplist[K, V]) release(seq uint64) error {
+	i := sort.Search(len(this.snapshots), func(i int) bool {
+		return this.snapshots[i] >= seq
+	})
+	this.snapshots = append(this.snapshots[:i], this.snapshots[i+1:]...)
+
+	return this.reclaim()
+}
+
+// pinned returns true if an open snapshot can see p. The caller must hold the lock.
+func (this *Skiplist[K, V]) pinned(p *node[K, V]) bool {
+	// The oldest snapshot that doesn't predate p is the only one that needs checking, newer
+	// ones can only see p if that one does
+	i := sort.Search(len(this.snapshots), func(i int) bool {
+		return this.snapshots[i] >= p.seq
+	})
+
+	return i < len(this.snapshots) && p.visible(this.snapshots[i])
+}
+
+// reclaim unlinks the tombstones that no open snapshot can see. The caller must hold the write
+// lock.
+func (this *Skiplist[K, V]) reclaim() error {
+	kept := this.tombstones[:0]
+
+	for i, p := range this.tombstones {
+		if this.pinned(p) {
+			kept = append(kept, p)
+			continue
+		}
+
+		if err := this.purge(p); err != nil {
+			// Keep the rest of the tombstones, they can be reclaimed when the next snapshot
+			// is closed
+			kept = append(kept, this.tombstones[i:]...)
+			clear(this.tombstones[len(kept):])
+			this.tombstones = kept
+			return errors.New("skiplist/reclaim: error reclaiming tombstones; " + err.Error())
+		}
+	}
+
+	clear(this.tombstones[len(kept):])
+	this.tombstones = kept
+
+	return nil
+}
+
+// purge unlinks the tombstone p. The caller must hold the write lock.
+func (this *Skiplist[K, V]) purge(p *node[K, V]) error {
+	f := this.deleteFingers
+	if err := this.updateSearchFingers(p.key, false, f); err != nil {
+		return err
+	}
+
+	// The fingers are before all the nodes with the same key as p, so move them up to p
+	for n := f.nodes[0].next[0]; n != p; n = n.next[0] {
+		if n == nil {
+			return errors.New("tombstone is not in the list")
+		}
+		this.pass(f, n)
+	}
+
+	this.unlink(f, p)
+	return nil
+}
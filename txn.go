@@ -0,0 +1,445 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import "errors"
+
+var (
+	// ErrConflict is returned by Commit when a range the transaction read was changed by another
+	// write since the transaction began.
+	ErrConflict = errors.New("skiplist/Commit: transaction conflicts with a committed write")
+)
+
+// Txn is an optimistic transaction. It reads from a snapshot of the list taken by Begin, and
+// buffers its inserts and deletes until Commit, so other readers and writers are never blocked by
+// it. Reads through the transaction see its own buffered writes.
+//
+// The transaction records the ranges it reads. Commit fails with ErrConflict if any node in those
+// ranges was inserted or deleted since Begin, whether by another transaction or by the list's own
+// methods. Otherwise the writes are applied as one batch, and the transaction is serializable:
+// it's as if it ran alone at the time of the commit.
+//
+// A Txn must not be used by more than one goroutine at a time, and can't be used once it's
+// committed or rolled back.
+type Txn[K, V any] struct {
+	list *Skiplist[K, V]
+	snap *Snapshot[K, V]
+
+	// Buffered writes, applied by Commit
+	writes *Batch[K, V]
+
+	// The inserts that no later delete of the transaction removed, and the bounds of its deletes,
+	// which reads merge with the snapshot
+	inserts *Skiplist[K, V]
+	deletes []bounds[K]
+
+	// Ranges read by the transaction
+	reads []bounds[K]
+
+	done bool
+}
+
+type bounds[K any] struct {
+	lo, hi Bound[K]
+}
+
+// Begin starts a transaction on the list.
+func (this *Skiplist[K, V]) Begin() *Txn[K, V] {
+	return &Txn[K, V]{
+		list:    this,
+		snap:    this.Snapshot(),
+		writes:  this.NewBatch(),
+		inserts: newSkiplist[K, V](this.compare),
+	}
+}
+
+func (this *Txn[K, V]) check(name string) error {
+	if this.done {
+		return errors.New("skiplist/Txn." + name + ": transaction is done")
+	}
+
+	return nil
+}
+
+func (this *Txn[K, V]) Insert(key K, value V) (err error) {
+	if err = this.check("Insert"); err != nil {
+		return err
+	}
+
+	if err = this.list.checkKey("Txn.Insert", key); err != nil {
+		return err
+	}
+
+	if _, err = this.inserts.Insert(key, value); err != nil {
+		return errors.New("skiplist/Txn.Insert: " + err.Error())
+	}
+
+	this.writes.Insert(key, value)
+	return nil
+}
+
+func (this *Txn[K, V]) Delete(key K) (err error) {
+	return this.deleteBounds("Delete", Inclusive(key), Inclusive(key))
+}
+
+func (this *Txn[K, V]) DeleteRange(key1, key2 K) (err error) {
+	return this.deleteBounds("DeleteRange", Inclusive(key1), Inclusive(key2))
+}
+
+func (this *Txn[K, V]) DeleteBounds(lo, hi Bound[K]) (err error) {
+	return this.deleteBounds("DeleteBounds", lo, hi)
+}
+
+func (this *Txn[K, V]) deleteBounds(name string, lo, hi Bound[K]) (err error) {
+	if err = this.check(name); err != nil {
+		return err
+	}
+
+	if err = this.list.checkBounds("Txn."+name, lo, hi); err != nil {
+		return err
+	}
+
+	if _, err = this.inserts.DeleteBounds(lo, hi); err != nil {
+		return errors.New("skiplist/Txn." + name + ": " + err.Error())
+	}
+
+	this.writes.DeleteBounds(lo, hi)
+	this.deletes = append(this.deletes, bounds[K]{lo, hi})
+	return nil
+}
+
+// Get returns the value of the first node with key, as seen by the transaction.
+func (this *Txn[K, V]) Get(key K) (value V, ok bool, err error) {
+	iter, err := this.selectBounds("Get", Inclusive(key), Inclusive(key))
+	if err != nil || !iter.Next() {
+		return value, false, err
+	}
+
+	return iter.Value(), true, nil
+}
+
+func (this *Txn[K, V]) Select(key K) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("Select", Inclusive(key), Inclusive(key))
+}
+
+func (this *Txn[K, V]) SelectRange(key1, key2 K) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("SelectRange", Inclusive(key1), Inclusive(key2))
+}
+
+func (this *Txn[K, V]) SelectBounds(lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	return this.selectBounds("SelectBounds", lo, hi)
+}
+
+// selectBounds returns a lazy iterator over the nodes between lo and hi in the snapshot, with the
+// transaction's writes on top of them, and records the range as read. As with other lazy
+// iterators, writes made by the transaction while iterating may or may not be seen.
+func (this *Txn[K, V]) selectBounds(name string, lo, hi Bound[K]) (iter *Iterator[K, V], err error) {
+	if err = this.check(name); err != nil {
+		return nil, err
+	}
+
+	if iter, err = this.snap.selectBounds(name, lo, hi); err != nil {
+		return nil, err
+	}
+	this.reads = append(this.reads, bounds[K]{lo, hi})
+
+	if this.writes.Len() == 0 {
+		return iter, nil
+	}
+
+	inserts, err := this.inserts.selectBounds("Txn."+name, lo, hi, nil, current)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator[K, V]{
+		cur: -1,
+		merge: &merge[K, V]{
+			list:    this.list,
+			inserts: inserts,
+			base:    iter,
+			deletes: this.deletes,
+			pos:     beforeFirst,
+		},
+	}, nil
+}
+
+// Commit applies the transaction's writes to the list, unless a range it read was changed since
+// the transaction began, in which case it returns ErrConflict and nothing is written. Either way,
+// the transaction is done.
+func (this *Txn[K, V]) Commit() (err error) {
+	if err = this.check("Commit"); err != nil {
+		return err
+	}
+	this.done = true
+
+	list := this.list
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	defer func() {
+		this.snap.closed.Store(true)
+		if rerr := list.release(this.snap.seq); err == nil {
+			err = rerr
+		}
+	}()
+
+	for _, r := range this.reads {
+		if changed, err := list.changed(r.lo, r.hi, this.snap.seq); err != nil {
+			return errors.New("skiplist/Commit: error checking for conflicts; " + err.Error())
+		} else if changed {
+			return ErrConflict
+		}
+	}
+
+	return list.apply("Commit", this.writes)
+}
+
+// Rollback discards the transaction's writes.
+func (this *Txn[K, V]) Rollback() (err error) {
+	if err = this.check("Rollback"); err != nil {
+		return err
+	}
+	this.done = true
+
+	return this.snap.Close()
+}
+
+// changed returns true if a node between lo and hi was inserted or deleted after sequence number
+// seq. An open snapshot at seq keeps the nodes deleted since then as tombstones, so they are
+// found too. The caller must hold the lock.
+func (this *Skiplist[K, V]) changed(lo, hi Bound[K], seq uint64) (bool, error) {
+	f := this.getFingers()
+	defer this.putFingers(f)
+
+	if err := this.seekLo(lo, f); err != nil {
+		return false, err
+	}
+
+	for p := f.nodes[0].next[0]; p != nil; p = p.next[0] {
+		if ok, err := this.beforeHi(hi, p.key); err != nil {
+			return false, err
+		} else if !ok {
+			break
+		}
+
+		if p.seq > seq || p.dead > seq {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// merge is a lazy iterator over a range of a transaction's snapshot, with the transaction's
+// writes on top: the nodes of the snapshot that none of its deletes cover, and its inserts. Among
+// equal keys, the inserts come last, as they will once they're committed.
+type merge[K, V any] struct {
+	list    *Skiplist[K, V]
+	inserts *Iterator[K, V]
+	base    *Iterator[K, V]
+	deletes []bounds[K]
+
+	// Whether inserts and base are on a node
+	onInsert, onBase bool
+
+	// The iterator the merge is on, its position, and whether it last moved backward, in which
+	// case the other iterator is on the node before, otherwise on the node after
+	cur      *Iterator[K, V]
+	pos      int
+	backward bool
+
+	count   int
+	counted bool
+	err     error
+}
+
+func (this *merge[K, V]) next() bool {
+	switch this.pos {
+	case beforeFirst:
+		return this.first()
+	case afterLast:
+		return false
+	}
+
+	if this.backward {
+		// Inserts with the same key come after base nodes
+		if key := this.cur.Key(); this.cur == this.inserts {
+			this.base.SeekLE(key)
+			this.onBase = this.skip(this.base, this.base.Next(), false)
+		} else {
+			this.onInsert = this.inserts.SeekGE(key)
+		}
+	}
+
+	if this.cur == this.inserts {
+		this.onInsert = this.inserts.Next()
+	} else {
+		this.onBase = this.skip(this.base, this.base.Next(), false)
+	}
+
+	return this.pick(false)
+}
+
+func (this *merge[K, V]) prev() bool {
+	switch this.pos {
+	case beforeFirst:
+		return false
+	case afterLast:
+		return this.last()
+	}
+
+	if !this.backward {
+		if key := this.cur.Key(); this.cur == this.inserts {
+			this.onBase = this.skip(this.base, this.base.SeekLE(key), true)
+		} else {
+			this.inserts.SeekGE(key)
+			this.onInsert = this.inserts.Prev()
+		}
+	}
+
+	if this.cur == this.inserts {
+		this.onInsert = this.inserts.Prev()
+	} else {
+		this.onBase = this.skip(this.base, this.base.Prev(), true)
+	}
+
+	return this.pick(true)
+}
+
+func (this *merge[K, V]) first() bool {
+	this.onInsert = this.inserts.First()
+	this.onBase = this.skip(this.base, this.base.First(), false)
+	return this.pick(false)
+}
+
+func (this *merge[K, V]) last() bool {
+	this.onInsert = this.inserts.Last()
+	this.onBase = this.skip(this.base, this.base.Last(), true)
+	return this.pick(true)
+}
+
+func (this *merge[K, V]) seekGE(key K) bool {
+	this.onInsert = this.inserts.SeekGE(key)
+	this.onBase = this.skip(this.base, this.base.SeekGE(key), false)
+	return this.pick(false)
+}
+
+func (this *merge[K, V]) seekLE(key K) bool {
+	this.onInsert = this.inserts.SeekLE(key)
+	this.onBase = this.skip(this.base, this.base.SeekLE(key), true)
+	return this.pick(true)
+}
+
+// pick moves the merge to the next node of inserts and base, or the previous one if backward is
+// true.
+func (this *merge[K, V]) pick(backward bool) bool {
+	this.cur, this.pos, this.backward = nil, afterLast, backward
+	if backward {
+		this.pos = beforeFirst
+	}
+
+	if this.err == nil {
+		if this.err = this.inserts.Err(); this.err == nil {
+			this.err = this.base.Err()
+		}
+	}
+
+	switch {
+	case this.err != nil:
+		return false
+	case this.onInsert && this.onBase:
+		c, err := this.list.compare(this.inserts.Key(), this.base.Key())
+		if err != nil {
+			this.err = errors.New("skiplist/Iterator: error comparing keys; " + err.Error())
+			return false
+		}
+
+		if backward && c >= 0 || !backward && c < 0 {
+			this.cur = this.inserts
+		} else {
+			this.cur = this.base
+		}
+	case this.onInsert:
+		this.cur = this.inserts
+	case this.onBase:
+		this.cur = this.base
+	default:
+		return false
+	}
+
+	this.pos = onNode
+	return true
+}
+
+// skip moves base, an iterator over the snapshot, past the nodes covered by the transaction's
+// deletes, in the direction it's moving, starting with the node it's on, if ok is true. It returns
+// false if base runs out of nodes.
+func (this *merge[K, V]) skip(base *Iterator[K, V], ok, backward bool) bool {
+	for ; ok; ok = this.move(base, backward) {
+		if covered, err := this.covered(base.Key()); err != nil {
+			this.err = errors.New("skiplist/Iterator: error comparing keys; " + err.Error())
+			return false
+		} else if !covered {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (this *merge[K, V]) move(iter *Iterator[K, V], backward bool) bool {
+	if backward {
+		return iter.Prev()
+	}
+	return iter.Next()
+}
+
+// covered returns true if one of the transaction's deletes includes key.
+func (this *merge[K, V]) covered(key K) (bool, error) {
+	for _, d := range this.deletes {
+		ok, err := this.list.afterLo(d.lo, key)
+		if err == nil && ok {
+			ok, err = this.list.beforeHi(d.hi, key)
+		}
+
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// total returns the number of nodes in the merge, walking the base range with a separate
+// iterator the first time.
+func (this *merge[K, V]) total() int {
+	if this.counted {
+		return this.count
+	}
+
+	base := newRangeIterator(this.base.list, this.base.seq, this.base.lo, this.base.hi, nil)
+	this.count = this.inserts.Count()
+	for ok := this.skip(base, base.First(), false); ok; ok = this.skip(base, base.Next(), false) {
+		this.count++
+	}
+
+	if this.err == nil {
+		this.err = base.Err()
+	}
+	this.counted = true
+
+	return this.count
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import "errors"
+
+// Get returns the value of the first node with key, and false if there's no such node.
+func (this *Skiplist[K, V]) Get(key K) (value V, ok bool, err error) {
+	return this.get(key, nil)
+}
+
+// get returns the value of the first node with key, using f to search for it, or fingers from
+// the pool if f is nil.
+func (this *Skiplist[K, V]) get(key K, f *fingers[K, V]) (value V, ok bool, err error) {
+	if err = this.checkKey("Get", key); err != nil {
+		return
+	}
+
+	if f == nil {
+		f = this.getFingers()
+		defer this.putFingers(f)
+	}
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	p, err := this.before(key, f)
+	if err != nil {
+		return
+	}
+
+	if p = this.skip(p.next[0], current, false); p != nil {
+		if c, err := this.compare(p.key, key); err != nil {
+			return value, false, errors.New("skiplist/Get: error comparing keys; " + err.Error())
+		} else if c == 0 {
+			return p.value, true, nil
+		}
+	}
+
+	return
+}
+
+// Upsert replaces the value of the first node with key, or inserts a new node if there's no
+// such node. It returns true if an existing value was replaced, in which case Count doesn't
+// change.
+func (this *Skiplist[K, V]) Upsert(key K, value V) (replaced bool, err error) {
+	if err = this.checkKey("Upsert", key); err != nil {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	p, err := this.locate(key)
+	if err != nil {
+		return false, err
+	}
+
+	if err = this.logNode("Upsert", walUpsert, key, value); err != nil {
+		return false, err
+	}
+
+	if p != nil {
+		this.replace(p, value)
+		return true, nil
+	}
+
+	this.insert(key, value)
+	return false, nil
+}
+
+// Update replaces the value of the first node with key by the result of fn, which is called
+// with the current value while the list is locked. It returns false if there's no such node.
+func (this *Skiplist[K, V]) Update(key K, fn func(value V) V) (ok bool, err error) {
+	if err = this.checkKey("Update", key); err != nil {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	p, err := this.locate(key)
+	if err != nil || p == nil {
+		return false, err
+	}
+
+	value := fn(p.value)
+	if err = this.logNode("Update", walUpsert, key, value); err != nil {
+		return false, err
+	}
+
+	this.replace(p, value)
+	return true, nil
+}
+
+// replace sets the value of p, which must have been found by locate. If a snapshot can see p,
+// the value can't change in place, so p is deleted and a new node is inserted with the value.
+// The caller must hold the write lock.
+func (this *Skiplist[K, V]) replace(p *node[K, V], value V) {
+	if !this.pinned(p) {
+		p.value = inlineBytes(this.arena, value)
+		return
+	}
+
+	// The insert fingers are before p and the tombstones with the same key, so move them up to p.
+	// Once p is removed, they are right after it, which is where the new node goes.
+	f := this.insertFingers
+	for n := f.nodes[0].next[0]; n != p; n = n.next[0] {
+		this.pass(f, n)
+	}
+
+	this.remove(f, p)
+	this.insert(p.key, value)
+}
+
+// InsertIfAbsent inserts key and value if there's no node with key in the list yet, and
+// returns true if it did.
+func (this *Skiplist[K, V]) InsertIfAbsent(key K, value V) (inserted bool, err error) {
+	if err = this.checkKey("InsertIfAbsent", key); err != nil {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	p, err := this.locate(key)
+	if err != nil || p != nil {
+		return false, err
+	}
+
+	if err = this.logNode("InsertIfAbsent", walInsert, key, value); err != nil {
+		return false, err
+	}
+
+	this.insert(key, value)
+	return true, nil
+}
@@ -0,0 +1,792 @@
+// Copyright (c) 2014 Dataence, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log is synced to disk, see SetSyncPolicy.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log after each write, before the write is applied. This is the default.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval syncs the log in the background, so a crash can lose the writes of the last
+	// interval, but never leaves the list out of order with the log.
+	SyncInterval
+
+	// SyncNever leaves it to the operating system to write the log to disk.
+	SyncNever
+)
+
+// Each record in the write-ahead log is framed as
+//
+//	length   uint32, little endian, the length of the payload
+//	crc      uint32, little endian, the CRC-32C of the payload
+//	payload  LSN uvarint, op byte, and the arguments of the op
+//
+// LSNs (log sequence numbers) start at 1 and increase by 1 with each record. Keys and values are
+// encoded with the list's codecs, and prefixed with their length as a uvarint, as in MarshalBinary.
+// Bounds are a kind byte, followed by the key unless the bound is unbounded.
+//
+// The log is split into segments, named after the LSN of their first record, so the records in
+// a checkpoint can be deleted with the segments they are in.
+const (
+	walPrefix     = "skiplist-"
+	walSuffix     = ".wal"
+	walHeaderSize = 8
+
+	walInsert   byte = 1 // key, value
+	walUpsert   byte = 2 // key, value
+	walDelete   byte = 3 // lo, hi
+	walDeleteAt byte = 4 // index uvarint, count uvarint
+	walBatch    byte = 5 // count uvarint, then walInsert key, value or walDelete lo, hi for each op
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// wal is the write-ahead log of a list. Records are appended while holding the list's write lock.
+type wal struct {
+	dir string
+
+	// Current segment of the log, and its size
+	file *os.File
+	size int64
+
+	// LSN of the last record
+	lsn uint64
+
+	// buffers reused for the records, and for the keys and values in them
+	buf     []byte
+	scratch []byte
+
+	policy SyncPolicy
+
+	// mutex protects dirty and err, which are shared with the background syncer
+	mutex sync.Mutex
+	dirty bool
+
+	// err is set once the log can't be trusted to match the list anymore, e.g. because a sync
+	// failed, and is returned by all appends after that
+	err error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetSyncPolicy sets when the write-ahead log is synced to disk. interval is how often the log is
+// synced with SyncInterval, and is ignored otherwise. The policy can be set before or after Open.
+func (this *Skiplist[K, V]) SetSyncPolicy(policy SyncPolicy, interval time.Duration) (err error) {
+	if policy < SyncAlways || policy > SyncNever {
+		return errors.New("skiplist/SetSyncPolicy: invalid policy " + strconv.Itoa(int(policy)))
+	}
+
+	if policy == SyncInterval && interval <= 0 {
+		return errors.New("skiplist/SetSyncPolicy: interval must be > 0")
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.syncPolicy, this.syncInterval = policy, interval
+	if this.wal != nil {
+		this.wal.stopSyncer()
+		this.wal.startSyncer(policy, interval)
+	}
+
+	return nil
+}
+
+// Open makes the list durable. It replays the write-ahead log in dir into the list, creating dir
+// and the log if they don't exist yet, and from then on appends each change to the log before
+// applying it. The list must be empty, and have the comparator, codecs and unique mode it had
+// when the log was written.
+//
+// A crash in the middle of a write can leave a torn record at the end of the log, which is cut
+// off, since the write it was for never returned. A bad record anywhere else means the log is
+// corrupt, and Open returns an error without changing the log.
+//
+// Once the list is open, a write that can't be logged isn't applied, and returns the error.
+func (this *Skiplist[K, V]) Open(dir string) (err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.wal != nil {
+		return errors.New("skiplist/Open: list is already open")
+	}
+
+	if this.count > 0 || len(this.tombstones) > 0 {
+		return errors.New("skiplist/Open: list is not empty")
+	}
+
+	if this.compare == nil {
+		return errors.New("skiplist/Open: comparator is not set (== nil)")
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return errors.New("skiplist/Open: " + err.Error())
+	}
+
+	w := &wal{dir: dir, policy: this.syncPolicy}
+	if err = this.recover(w); err != nil {
+		if w.file != nil {
+			w.file.Close()
+		}
+
+		// Leave the list empty, as it was
+		this.load(&decoded[K, V]{
+			headNode: newNode[K, V](this.maxLevel),
+			maxLevel: this.maxLevel,
+			ip:       this.ip,
+			unique:   this.unique,
+			level:    1,
+			seq:      this.seq,
+		})
+		return errors.New("skiplist/Open: " + err.Error())
+	}
+
+	w.startSyncer(this.syncPolicy, this.syncInterval)
+	this.wal = w
+
+	return nil
+}
+
+// Close closes the write-ahead log, after syncing it. Changes made to the list after Close are
+// not logged.
+func (this *Skiplist[K, V]) Close() (err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.wal == nil {
+		return nil
+	}
+
+	err = this.wal.close()
+	this.wal = nil
+
+	if err != nil {
+		return errors.New("skiplist/Close: " + err.Error())
+	}
+	return nil
+}
+
+// recover loads the checkpoint in w.dir, if there is one, and replays the log segments, skipping
+// the records in the checkpoint. It leaves w.file open on the last segment, creating it if there
+// are none. The caller must hold the write lock.
+func (this *Skiplist[K, V]) recover(w *wal) error {
+	checkpoint, err := this.restore(w.dir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	// Entries are sorted by name, and so the segments by LSN
+	var segments []string
+	for _, e := range entries {
+		if isSegment(e.Name()) {
+			segments = append(segments, e.Name())
+		}
+	}
+
+	for i, name := range segments {
+		if w.file, err = os.OpenFile(filepath.Join(w.dir, name), os.O_RDWR|os.O_APPEND, 0); err != nil {
+			return err
+		}
+		w.size = 0
+
+		if err = this.replay(w, checkpoint, i == len(segments)-1); err != nil {
+			return errors.New(name + ": " + err.Error())
+		}
+
+		if i < len(segments)-1 {
+			w.file.Close()
+			w.file = nil
+		}
+	}
+
+	w.lsn = max(w.lsn, checkpoint)
+	if w.file == nil {
+		w.file, err = createSegment(w.dir, w.lsn+1)
+	}
+
+	return err
+}
+
+// replay applies the records in the segment w.file to the list, except for those with LSNs up
+// to checkpoint. If tail is true, the segment is the last one, and a torn record at its end is
+// cut off. The caller must hold the write lock.
+func (this *Skiplist[K, V]) replay(w *wal, checkpoint uint64, tail bool) error {
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	r := bufio.NewReader(io.NewSectionReader(w.file, 0, size))
+	var header [walHeaderSize]byte
+	var payload []byte
+
+	// Only the last segment can have been torn by a crash, the others were synced before the
+	// next one was started
+	torn := func(reason string) error {
+		if tail {
+			return w.truncate(reason)
+		}
+		return errors.New("log is corrupt, " + reason + " at offset " + strconv.FormatInt(w.size, 10))
+	}
+
+	for {
+		if _, err = io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return torn("partial record header")
+		} else if err != nil {
+			return err
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header[0:]))
+		end := w.size + walHeaderSize + length
+		if end > size {
+			return torn("partial record")
+		}
+
+		if int64(cap(payload)) < length {
+			payload = make([]byte, length)
+		}
+		payload = payload[:length]
+
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return err
+		}
+
+		if length == 0 || crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+			// A torn write only damages the last record, but the file system can also leave
+			// zeros after it, if it extended the file before the write reached the disk
+			if end == size || zeros(r) {
+				return torn("bad record")
+			}
+			return errors.New("log is corrupt, bad record at offset " + strconv.FormatInt(w.size, 10))
+		}
+
+		// Records can be missing from the log if they are in the checkpoint, e.g. because the
+		// segments it includes weren't all deleted yet, but not after it
+		lsn, n := binary.Uvarint(payload)
+		if n <= 0 {
+			err = errors.New("invalid record")
+		} else if lsn <= w.lsn {
+			err = errors.New("LSN " + strconv.FormatUint(lsn, 10) + " is out of order")
+		} else if next := max(w.lsn, checkpoint) + 1; lsn > next {
+			err = errors.New("log is missing the records from LSN " + strconv.FormatUint(next, 10))
+		} else if lsn > checkpoint {
+			err = this.redo(payload)
+		}
+
+		if err != nil {
+			return errors.New("error replaying record at offset " + strconv.FormatInt(w.size, 10) + "; " + err.Error())
+		}
+
+		w.size, w.lsn = end, lsn
+	}
+
+	return nil
+}
+
+// zeros returns true if the rest of r is zeros.
+func zeros(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err == io.EOF
+		} else if b != 0 {
+			return false
+		}
+	}
+}
+
+// redo applies a record to the list. Writes are only logged once they have applied, so a record
+// that fails means the comparator changed, or the log is corrupt, and the error is returned. The
+// caller must hold the write lock.
+func (this *Skiplist[K, V]) redo(payload []byte) (err error) {
+	r := &walReader{data: payload}
+	r.uvarint() // LSN, checked by replay
+	op := r.byte()
+
+	switch op {
+	case walInsert, walUpsert:
+		var key K
+		var value V
+		if key, value, err = this.readNode(r); err != nil {
+			return
+		}
+
+		var p *node[K, V]
+		if op == walInsert && !this.unique {
+			err = this.locateEnd(key)
+		} else {
+			p, err = this.locate(key)
+		}
+
+		if err != nil {
+			return
+		}
+
+		if op == walUpsert && p != nil {
+			this.replace(p, value)
+		} else if op == walInsert && p != nil && this.unique {
+			err = errors.New("duplicate key in a unique list")
+		} else {
+			this.insert(key, value)
+		}
+
+	case walDelete:
+		var lo, hi Bound[K]
+		if lo, hi, err = this.readBounds(r); err == nil {
+			_, err = this.deleteRange("Open", lo, hi, nil)
+		}
+
+	case walDeleteAt:
+		i, n := r.uvarint(), r.uvarint()
+		for ; r.err == nil && n > 0 && i < uint64(this.count); n-- {
+			this.remove(this.deleteFingers, this.nodeAt(int(i), this.deleteFingers))
+		}
+
+	case walBatch:
+		batch := this.NewBatch()
+		for n := r.uvarint(); r.err == nil && n > 0; n-- {
+			switch r.byte() {
+			case walInsert:
+				o := batchOp[K, V]{insert: true}
+				if o.key, o.value, err = this.readNode(r); err != nil {
+					return
+				}
+				batch.ops = append(batch.ops, o)
+
+			case walDelete:
+				var o batchOp[K, V]
+				if o.lo, o.hi, err = this.readBounds(r); err != nil {
+					return
+				}
+				batch.ops = append(batch.ops, o)
+
+			default:
+				r.fail()
+			}
+		}
+
+		if r.err == nil {
+			err = this.apply("Open", batch)
+		}
+
+	default:
+		r.fail()
+	}
+
+	if err == nil && (r.err != nil || len(r.data) > 0) {
+		err = errors.New("invalid record")
+	}
+
+	return
+}
+
+// readNode reads a key and a value from r.
+func (this *Skiplist[K, V]) readNode(r *walReader) (key K, value V, err error) {
+	if key, err = this.readKey(r); err != nil {
+		return
+	}
+
+	if value, err = this.valueCodec.Decode(r.bytes()); err != nil {
+		err = errors.New("error decoding value; " + err.Error())
+	}
+	return
+}
+
+// readBounds reads a lo and a hi bound from r.
+func (this *Skiplist[K, V]) readBounds(r *walReader) (lo, hi Bound[K], err error) {
+	for _, b := range []*Bound[K]{&lo, &hi} {
+		if b.kind = boundKind(r.byte()); b.kind < unbounded || b.kind > exclusive {
+			return lo, hi, errors.New("invalid bound")
+		} else if b.kind != unbounded {
+			if b.key, err = this.readKey(r); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func (this *Skiplist[K, V]) readKey(r *walReader) (key K, err error) {
+	data := r.bytes()
+	if r.err != nil {
+		return key, errors.New("invalid record")
+	}
+
+	if key, err = this.keyCodec.Decode(data); err != nil {
+		return key, errors.New("error decoding key; " + err.Error())
+	}
+
+	return key, this.checkKey("Open", key)
+}
+
+// walReader reads the fields of a record. Once a read runs past the end of the record, err is
+// set, and all reads return zero values.
+type walReader struct {
+	data []byte
+	err  error
+}
+
+func (this *walReader) fail() {
+	this.data, this.err = nil, errors.New("invalid record")
+}
+
+func (this *walReader) uvarint() uint64 {
+	u, n := binary.Uvarint(this.data)
+	if n <= 0 {
+		this.fail()
+		return 0
+	}
+
+	this.data = this.data[n:]
+	return u
+}
+
+func (this *walReader) byte() byte {
+	if len(this.data) == 0 {
+		this.fail()
+		return 0
+	}
+
+	b := this.data[0]
+	this.data = this.data[1:]
+	return b
+}
+
+func (this *walReader) bytes() []byte {
+	n := this.uvarint()
+	if n > uint64(len(this.data)) {
+		this.fail()
+		return nil
+	}
+
+	b := this.data[:n]
+	this.data = this.data[n:]
+	return b
+}
+
+// logNode logs an insert or upsert of key and value, for the method name. It does nothing if
+// the list has no log. The caller must hold the write lock, as do the other log methods.
+func (this *Skiplist[K, V]) logNode(name string, op byte, key K, value V) error {
+	if this.wal == nil {
+		return nil
+	}
+
+	buf, err := this.appendNode(this.wal.begin(op), key, value)
+	return this.logRecord(name, buf, err)
+}
+
+// logDelete logs a delete of the nodes between lo and hi.
+func (this *Skiplist[K, V]) logDelete(name string, lo, hi Bound[K]) error {
+	if this.wal == nil {
+		return nil
+	}
+
+	buf, err := this.appendBounds(this.wal.begin(walDelete), lo, hi)
+	return this.logRecord(name, buf, err)
+}
+
+// logDeleteAt logs a delete of n nodes from the 0-based position i.
+func (this *Skiplist[K, V]) logDeleteAt(name string, i, n int) error {
+	if this.wal == nil {
+		return nil
+	}
+
+	buf := binary.AppendUvarint(this.wal.begin(walDeleteAt), uint64(i))
+	buf = binary.AppendUvarint(buf, uint64(n))
+	return this.logRecord(name, buf, nil)
+}
+
+// logBatch logs all the operations of batch in one record, so they are replayed together.
+func (this *Skiplist[K, V]) logBatch(name string, batch *Batch[K, V]) (err error) {
+	if this.wal == nil {
+		return nil
+	}
+
+	buf := binary.AppendUvarint(this.wal.begin(walBatch), uint64(len(batch.ops)))
+	for _, op := range batch.ops {
+		if op.insert {
+			buf, err = this.appendNode(append(buf, walInsert), op.key, op.value)
+		} else {
+			buf, err = this.appendBounds(append(buf, walDelete), op.lo, op.hi)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return this.logRecord(name, buf, err)
+}
+
+// logRecord appends the record in buf to the log, unless encoding it failed with err.
+func (this *Skiplist[K, V]) logRecord(name string, buf []byte, err error) error {
+	if err == nil {
+		err = this.wal.append(buf)
+	}
+
+	if err != nil {
+		return errors.New("skiplist/" + name + ": error writing to the log; " + err.Error())
+	}
+	return nil
+}
+
+func (this *Skiplist[K, V]) appendNode(buf []byte, key K, value V) (_ []byte, err error) {
+	if buf, err = appendEncoded(this.wal, buf, this.keyCodec, key); err != nil {
+		return nil, errors.New("error encoding key; " + err.Error())
+	}
+
+	if buf, err = appendEncoded(this.wal, buf, this.valueCodec, value); err != nil {
+		return nil, errors.New("error encoding value; " + err.Error())
+	}
+
+	return buf, nil
+}
+
+func (this *Skiplist[K, V]) appendBounds(buf []byte, lo, hi Bound[K]) (_ []byte, err error) {
+	for _, b := range []Bound[K]{lo, hi} {
+		buf = append(buf, byte(b.kind))
+		if b.kind == unbounded {
+			continue
+		}
+
+		if buf, err = appendEncoded(this.wal, buf, this.keyCodec, b.key); err != nil {
+			return nil, errors.New("error encoding key; " + err.Error())
+		}
+	}
+
+	return buf, nil
+}
+
+// appendEncoded appends v to buf, encoded with codec and prefixed with its length.
+func appendEncoded[T any](w *wal, buf []byte, codec Codec[T], v T) ([]byte, error) {
+	data, err := codec.Append(w.scratch[:0], v)
+	if err != nil {
+		return nil, err
+	}
+	w.scratch = data
+
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+// begin starts the next record, with room for the header, which is filled in by append.
+func (this *wal) begin(op byte) []byte {
+	buf := append(this.buf[:0], make([]byte, walHeaderSize)...)
+	buf = binary.AppendUvarint(buf, this.lsn+1)
+	return append(buf, op)
+}
+
+// append writes the record started by begin to the log, and syncs it if the policy is
+// SyncAlways. If the write fails, the log is cut back to its previous size, so the next record
+// doesn't follow a partial one.
+func (this *wal) append(buf []byte) (err error) {
+	this.buf = buf
+
+	payload := buf[walHeaderSize:]
+	if uint64(len(payload)) > maxDataLength {
+		return errors.New("record is too large")
+	}
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.err != nil {
+		return this.err
+	}
+
+	if _, err = this.file.Write(buf); err == nil && this.policy == SyncAlways {
+		// After a failed sync, it's unknown what made it to disk, so the log is unusable
+		if err = this.file.Sync(); err != nil {
+			this.err = errors.New("log is unusable after a failed sync; " + err.Error())
+		}
+	}
+
+	if err != nil {
+		if terr := this.file.Truncate(this.size); terr != nil && this.err == nil {
+			this.err = errors.New("log is unusable after a failed write; " + terr.Error())
+		}
+		return err
+	}
+
+	this.size += int64(len(buf))
+	this.lsn++
+	this.dirty = this.policy != SyncAlways
+
+	return nil
+}
+
+// rotate starts a new segment for the records after the last one, unless the current segment is
+// still empty. The caller must hold the list's write lock.
+func (this *wal) rotate() error {
+	if this.size == 0 {
+		return nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// The segment must be on disk before the next one is created, so that only the last segment
+	// can have a torn record after a crash
+	if this.err == nil {
+		if err := this.file.Sync(); err != nil {
+			this.err = errors.New("log is unusable after a failed sync; " + err.Error())
+		}
+	}
+
+	if this.err != nil {
+		return this.err
+	}
+
+	file, err := createSegment(this.dir, this.lsn+1)
+	if err != nil {
+		return err
+	}
+
+	this.file.Close()
+	this.file, this.size, this.dirty = file, 0, false
+
+	return nil
+}
+
+// segmentName returns the name of the log segment starting at lsn.
+func segmentName(lsn uint64) string {
+	return fmt.Sprintf("%s%020d%s", walPrefix, lsn, walSuffix)
+}
+
+func isSegment(name string) bool {
+	return len(name) == len(segmentName(0)) && strings.HasPrefix(name, walPrefix) && strings.HasSuffix(name, walSuffix)
+}
+
+// createSegment creates the log segment starting at lsn in dir.
+func createSegment(dir string, lsn uint64) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, segmentName(lsn)), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	syncDir(dir)
+	return file, nil
+}
+
+// syncDir makes sure files created or renamed in dir are found after a crash. Directories can't
+// be synced on all platforms, in which case there's nothing more to do.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// truncate cuts the log off at its current size, after a torn record found by replay.
+func (this *wal) truncate(reason string) error {
+	if err := this.file.Truncate(this.size); err != nil {
+		return errors.New("error truncating " + reason + "; " + err.Error())
+	}
+
+	return this.file.Sync()
+}
+
+// startSyncer starts syncing the log in the background, if policy is SyncInterval.
+func (this *wal) startSyncer(policy SyncPolicy, interval time.Duration) {
+	this.mutex.Lock()
+	this.policy = policy
+	this.mutex.Unlock()
+
+	if policy != SyncInterval {
+		return
+	}
+
+	this.stop, this.done = make(chan struct{}), make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				this.sync()
+			}
+		}
+	}(this.stop, this.done)
+}
+
+func (this *wal) stopSyncer() {
+	if this.stop != nil {
+		close(this.stop)
+		<-this.done
+		this.stop, this.done = nil, nil
+	}
+}
+
+// sync syncs the log if it was written to since the last sync.
+func (this *wal) sync() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.err != nil || !this.dirty {
+		return this.err
+	}
+
+	if err := this.file.Sync(); err != nil {
+		this.err = errors.New("log is unusable after a failed sync; " + err.Error())
+		return this.err
+	}
+
+	this.dirty = false
+	return nil
+}
+
+func (this *wal) close() error {
+	this.stopSyncer()
+
+	err := this.sync()
+	if cerr := this.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}